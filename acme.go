@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// letsEncryptURL is the directory of the Let's Encrypt production CA.
+const letsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// acmeChallengePath is where HTTP-01 challenge responses are served.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+var (
+	// acmePollInterval is how often pending authorizations and orders are
+	// checked.
+	acmePollInterval = 2 * time.Second
+
+	// acmeRenewInterval is how often the certificate is checked for
+	// renewal.
+	acmeRenewInterval = 12 * time.Hour
+)
+
+// acmePollAttempts bounds how many times an authorization or order is
+// checked before giving up.
+const acmePollAttempts = 60
+
+// acmeTimeout bounds each request to the CA.
+const acmeTimeout = time.Minute
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	Status         string       `json:"status"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *acmeProblem `json:"error"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type   string       `json:"type"`
+	URL    string       `json:"url"`
+	Token  string       `json:"token"`
+	Status string       `json:"status"`
+	Error  *acmeProblem `json:"error"`
+}
+
+// acmeProblem is an error document returned by an ACME server.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *acmeProblem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", p.Type, p.Detail)
+}
+
+// acmeManager obtains and renews a certificate for a set of hosts from an
+// ACME CA, such as Let's Encrypt, answering HTTP-01 challenges. The account
+// key, certificate and certificate key are cached in CacheDir.
+type acmeManager struct {
+	DirectoryURL string
+	Email        string
+	Hosts        []string
+	CacheDir     string
+
+	// Client is used to talk to the CA. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// RenewBefore is how long before it expires the certificate is renewed.
+	// If zero, certificates are renewed 30 days before they expire.
+	RenewBefore time.Duration
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	tokens map[string]string // challenge token to key authorization
+
+	key   *ecdsa.PrivateKey
+	kid   string
+	dir   acmeDirectory
+	nonce string
+}
+
+// GetCertificate returns the current certificate. It is intended for use as
+// tls.Config.GetCertificate.
+func (m *acmeManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, fmt.Errorf("acme: no certificate has been obtained")
+	}
+	return m.cert, nil
+}
+
+// HTTPHandler answers HTTP-01 challenges, passing all other requests to
+// fallback.
+func (m *acmeManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.URL.Path, acmeChallengePath)
+		m.mu.Lock()
+		keyAuth, ok := m.tokens[token]
+		m.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, keyAuth)
+	})
+}
+
+// Start loads a cached certificate, obtaining a new one if there is none or
+// it is due for renewal. The HTTP-01 challenge handler must already be
+// serving.
+func (m *acmeManager) Start() error {
+	if err := os.MkdirAll(m.CacheDir, 0700); err != nil {
+		return err
+	}
+	if err := m.loadCached(); err != nil && !os.IsNotExist(err) {
+		log.Printf("acme: ignoring cached certificate: %v", err)
+	}
+	if !m.needsRenewal() {
+		return nil
+	}
+	return m.obtain()
+}
+
+// renewLoop periodically renews the certificate. It never returns.
+func (m *acmeManager) renewLoop() {
+	for {
+		time.Sleep(acmeRenewInterval)
+		if !m.needsRenewal() {
+			continue
+		}
+		if err := m.obtain(); err != nil {
+			log.Printf("acme: renewing certificate: %v", err)
+		}
+	}
+}
+
+func (m *acmeManager) cachePath(name string) string {
+	return filepath.Join(m.CacheDir, name)
+}
+
+// loadCached loads the cached certificate if it covers every host.
+func (m *acmeManager) loadCached() error {
+	cert, err := tls.LoadX509KeyPair(m.cachePath("cert.pem"), m.cachePath("cert.key"))
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	for _, host := range m.Hosts {
+		if err := leaf.VerifyHostname(host); err != nil {
+			return fmt.Errorf("cached certificate does not cover %s", host)
+		}
+	}
+	cert.Leaf = leaf
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// needsRenewal reports if there is no certificate or it expires soon.
+func (m *acmeManager) needsRenewal() bool {
+	renewBefore := m.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = 30 * 24 * time.Hour
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert == nil || m.cert.Leaf == nil || time.Until(m.cert.Leaf.NotAfter) < renewBefore
+}
+
+// obtain orders a new certificate for the hosts and caches it.
+func (m *acmeManager) obtain() error {
+	if err := m.register(); err != nil {
+		return err
+	}
+
+	var ids []acmeIdentifier
+	for _, host := range m.Hosts {
+		ids = append(ids, acmeIdentifier{Type: "dns", Value: host})
+	}
+	var order acmeOrder
+	header, err := m.postJSON(m.dir.NewOrder, map[string]interface{}{"identifiers": ids}, &order)
+	if err != nil {
+		return fmt.Errorf("creating order: %v", err)
+	}
+	orderURL := header.Get("Location")
+	for _, authzURL := range order.Authorizations {
+		if err := m.authorize(authzURL); err != nil {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.Hosts[0]},
+		DNSNames: m.Hosts,
+	}, key)
+	if err != nil {
+		return err
+	}
+	if _, err := m.postJSON(order.Finalize, map[string]string{"csr": b64(csr)}, &order); err != nil {
+		return fmt.Errorf("finalizing order: %v", err)
+	}
+	for i := 0; order.Status != "valid"; i++ {
+		if order.Status == "invalid" || i == acmePollAttempts {
+			return fmt.Errorf("order %s is %s: %v", orderURL, order.Status, order.Error)
+		}
+		time.Sleep(acmePollInterval)
+		if _, err := m.postJSON(orderURL, nil, &order); err != nil {
+			return fmt.Errorf("checking order: %v", err)
+		}
+	}
+	_, chain, err := m.post(order.Certificate, nil)
+	if err != nil {
+		return fmt.Errorf("downloading certificate: %v", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if _, err := tls.X509KeyPair(chain, keyPEM); err != nil {
+		return fmt.Errorf("CA returned an unusable certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(m.cachePath("cert.key"), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.cachePath("cert.pem"), chain, 0644); err != nil {
+		return err
+	}
+	return m.loadCached()
+}
+
+// authorize completes the HTTP-01 challenge of an authorization.
+func (m *acmeManager) authorize(authzURL string) error {
+	var authz acmeAuthorization
+	if _, err := m.postJSON(authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("fetching authorization: %v", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	var chal *acmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	m.mu.Lock()
+	if m.tokens == nil {
+		m.tokens = make(map[string]string)
+	}
+	m.tokens[chal.Token] = chal.Token + "." + m.thumbprint()
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.tokens, chal.Token)
+		m.mu.Unlock()
+	}()
+
+	if _, err := m.postJSON(chal.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("accepting challenge: %v", err)
+	}
+	for i := 0; i < acmePollAttempts; i++ {
+		time.Sleep(acmePollInterval)
+		if _, err := m.postJSON(authzURL, nil, &authz); err != nil {
+			return fmt.Errorf("checking authorization: %v", err)
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending":
+		default:
+			for _, c := range authz.Challenges {
+				if c.Error != nil {
+					return fmt.Errorf("authorizing %s: %v", authz.Identifier.Value, c.Error)
+				}
+			}
+			return fmt.Errorf("authorization of %s is %s", authz.Identifier.Value, authz.Status)
+		}
+	}
+	return fmt.Errorf("timed out authorizing %s", authz.Identifier.Value)
+}
+
+// register loads or creates the account key and registers it with the CA.
+func (m *acmeManager) register() error {
+	if m.kid != "" {
+		return nil
+	}
+	if err := m.loadAccountKey(); err != nil {
+		return err
+	}
+	resp, err := m.client().Get(m.DirectoryURL)
+	if err != nil {
+		return fmt.Errorf("fetching directory: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching directory: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m.dir); err != nil {
+		return fmt.Errorf("decoding directory: %v", err)
+	}
+
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if m.Email != "" {
+		account["contact"] = []string{"mailto:" + m.Email}
+	}
+	header, err := m.postJSON(m.dir.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("registering account: %v", err)
+	}
+	m.kid = header.Get("Location")
+	if m.kid == "" {
+		return fmt.Errorf("registering account: no account URL returned")
+	}
+	return nil
+}
+
+func (m *acmeManager) loadAccountKey() error {
+	filename := m.cachePath("account.key")
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s: no PEM data", filename)
+		}
+		m.key, err = x509.ParseECPrivateKey(block.Bytes)
+		return err
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if m.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(m.key)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func (m *acmeManager) client() *http.Client {
+	if m.Client == nil {
+		return http.DefaultClient
+	}
+	return m.Client
+}
+
+// postJSON is post, decoding the JSON response into v if v is not nil.
+func (m *acmeManager) postJSON(url string, payload, v interface{}) (http.Header, error) {
+	header, body, err := m.post(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if err := json.Unmarshal(body, v); err != nil {
+			return nil, fmt.Errorf("decoding response from %s: %v", url, err)
+		}
+	}
+	return header, nil
+}
+
+// post sends a JWS signed request. A nil payload makes a POST-as-GET
+// request.
+func (m *acmeManager) post(url string, payload interface{}) (http.Header, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := m.sign(url, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := m.client().Post(url, "application/jose+json", bytes.NewReader(req))
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		m.nonce = resp.Header.Get("Replay-Nonce")
+		if resp.StatusCode < 400 {
+			return resp.Header, body, nil
+		}
+		prob := &acmeProblem{Status: resp.StatusCode}
+		if err := json.Unmarshal(body, prob); err != nil || prob.Type == "" {
+			return nil, nil, fmt.Errorf("acme: %s from %s", resp.Status, url)
+		}
+		// nonces can be rejected at any time and the request retried
+		if prob.Type == "urn:ietf:params:acme:error:badNonce" && attempt < 3 {
+			continue
+		}
+		return nil, nil, prob
+	}
+}
+
+func (m *acmeManager) fetchNonce() (string, error) {
+	if m.nonce != "" {
+		nonce := m.nonce
+		m.nonce = ""
+		return nonce, nil
+	}
+	resp, err := m.client().Head(m.dir.NewNonce)
+	if err != nil {
+		return "", fmt.Errorf("fetching nonce: %v", err)
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("fetching nonce: none returned")
+	}
+	return nonce, nil
+}
+
+// sign encodes a request as a flattened JWS signed with the account key.
+func (m *acmeManager) sign(url string, payload interface{}) ([]byte, error) {
+	nonce, err := m.fetchNonce()
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if m.kid == "" {
+		protected["jwk"] = m.jwk()
+	} else {
+		protected["kid"] = m.kid
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	signed := b64(header) + "." + b64(body)
+	hash := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, m.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return json.Marshal(map[string]string{
+		"protected": b64(header),
+		"payload":   b64(body),
+		"signature": b64(sig),
+	})
+}
+
+// jwk returns the public account key as a JSON Web Key.
+func (m *acmeManager) jwk() map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	m.key.PublicKey.X.FillBytes(x)
+	m.key.PublicKey.Y.FillBytes(y)
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": b64(x), "y": b64(y)}
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the account key.
+func (m *acmeManager) thumbprint() string {
+	// encoding/json sorts map keys, giving the required member order
+	data, _ := json.Marshal(m.jwk())
+	h := crypto.SHA256.New()
+	h.Write(data)
+	return b64(h.Sum(nil))
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// acmeClient returns an HTTP client for talking to a CA, trusting the
+// certificates in caFile in addition to the system roots. Test CAs such as
+// Pebble serve their directory with a certificate from their own root.
+func acmeClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return &http.Client{Timeout: acmeTimeout}, nil
+	}
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport, Timeout: acmeTimeout}, nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acmeServer is a minimal stand-in for an ACME CA, such as Pebble, which
+// verifies request signatures and HTTP-01 challenge responses.
+type acmeServer struct {
+	t         *testing.T
+	url       string
+	challenge string // base URL the HTTP-01 responses are fetched from
+
+	mu       sync.Mutex
+	nonce    int
+	nonces   map[string]bool
+	rejected bool // whether a nonce has been rejected yet
+	accounts map[string]*ecdsa.PublicKey
+	authzs   map[string]string // host to status
+	orders   int
+	hosts    []string
+	cert     []byte
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+}
+
+func newACMEServer(t *testing.T) (*acmeServer, *httptest.Server) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	s := &acmeServer{
+		t:        t,
+		nonces:   make(map[string]bool),
+		accounts: make(map[string]*ecdsa.PublicKey),
+		authzs:   make(map[string]string),
+		caKey:    key,
+		caCert:   ca,
+	}
+	srv := httptest.NewServer(s)
+	s.url = srv.URL
+	return s, srv
+}
+
+func (s *acmeServer) newNonce() string {
+	s.nonce++
+	n := fmt.Sprintf("nonce-%d", s.nonce)
+	s.nonces[n] = true
+	return n
+}
+
+func (s *acmeServer) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(acmeProblem{Type: "urn:ietf:params:acme:error:" + typ, Detail: detail, Status: status})
+}
+
+// verify checks a JWS request, returning its payload and the account key
+// which signed it.
+func (s *acmeServer) verify(r *http.Request) ([]byte, *ecdsa.PublicKey, string, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, nil, "malformed", err
+	}
+	dec := base64.RawURLEncoding.DecodeString
+	header, err := dec(jws.Protected)
+	if err != nil {
+		return nil, nil, "malformed", err
+	}
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, nil, "malformed", err
+	}
+	if !s.nonces[protected.Nonce] {
+		return nil, nil, "badNonce", fmt.Errorf("unknown nonce %q", protected.Nonce)
+	}
+	delete(s.nonces, protected.Nonce)
+	if !s.rejected {
+		s.rejected = true
+		return nil, nil, "badNonce", fmt.Errorf("nonce rejected to test retries")
+	}
+	if protected.URL != s.url+r.URL.Path {
+		return nil, nil, "unauthorized", fmt.Errorf("signed URL %s does not match %s", protected.URL, r.URL.Path)
+	}
+
+	var key *ecdsa.PublicKey
+	if protected.JWK != nil {
+		x, _ := dec(protected.JWK["x"])
+		y, _ := dec(protected.JWK["y"])
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if key = s.accounts[protected.Kid]; key == nil {
+		return nil, nil, "accountDoesNotExist", fmt.Errorf("unknown account %q", protected.Kid)
+	}
+	sig, err := dec(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, nil, "malformed", fmt.Errorf("bad signature encoding")
+	}
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, nil, "unauthorized", fmt.Errorf("bad signature")
+	}
+	payload, err := dec(jws.Payload)
+	if err != nil {
+		return nil, nil, "malformed", err
+	}
+	return payload, key, "", nil
+}
+
+func (s *acmeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Replay-Nonce", s.newNonce())
+
+	switch r.URL.Path {
+	case "/dir":
+		json.NewEncoder(w).Encode(acmeDirectory{
+			NewNonce:   s.url + "/nonce",
+			NewAccount: s.url + "/account",
+			NewOrder:   s.url + "/order",
+		})
+		return
+	case "/nonce":
+		return
+	}
+
+	payload, key, problem, err := s.verify(r)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, problem, err.Error())
+		return
+	}
+	path := r.URL.Path
+	switch {
+	case path == "/account":
+		kid := s.url + "/account/1"
+		s.accounts[kid] = key
+		w.Header().Set("Location", kid)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	case path == "/order":
+		var req struct{ Identifiers []acmeIdentifier }
+		json.Unmarshal(payload, &req)
+		s.orders++
+		s.hosts = nil
+		s.cert = nil
+		for _, id := range req.Identifiers {
+			s.hosts = append(s.hosts, id.Value)
+			s.authzs[id.Value] = "pending"
+		}
+		w.Header().Set("Location", s.url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s.order())
+	case path == "/order/1":
+		json.NewEncoder(w).Encode(s.order())
+	case strings.HasPrefix(path, "/authz/"):
+		host := strings.TrimPrefix(path, "/authz/")
+		json.NewEncoder(w).Encode(acmeAuthorization{
+			Status:     s.authzs[host],
+			Identifier: acmeIdentifier{Type: "dns", Value: host},
+			Challenges: []acmeChallenge{
+				{Type: "dns-01", URL: s.url + "/chal/dns/" + host, Token: "dns-" + host},
+				{Type: "http-01", URL: s.url + "/chal/" + host, Token: "tok-" + host},
+			},
+		})
+	case strings.HasPrefix(path, "/chal/"):
+		host := strings.TrimPrefix(path, "/chal/")
+		token := "tok-" + host
+		resp, err := http.Get(s.challenge + acmeChallengePath + token)
+		if err != nil {
+			s.t.Errorf("fetching challenge response: %v", err)
+			s.authzs[host] = "invalid"
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		m := &acmeManager{key: &ecdsa.PrivateKey{PublicKey: *key}}
+		if string(body) == token+"."+m.thumbprint() {
+			s.authzs[host] = "valid"
+		} else {
+			s.t.Errorf("unexpected key authorization %q", body)
+			s.authzs[host] = "invalid"
+		}
+		fmt.Fprint(w, `{"status":"processing"}`)
+	case path == "/finalize/1":
+		if s.order().Status != "ready" {
+			s.problem(w, http.StatusForbidden, "orderNotReady", "authorizations are not valid")
+			return
+		}
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			s.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(s.orders + 1)),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, tmpl, s.caCert, csr.PublicKey, s.caKey)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		s.cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+		json.NewEncoder(w).Encode(s.order())
+	case path == "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.cert)
+	default:
+		s.problem(w, http.StatusNotFound, "malformed", "not found")
+	}
+}
+
+// order returns the current order, its status derived from its
+// authorizations.
+func (s *acmeServer) order() acmeOrder {
+	o := acmeOrder{Status: "ready", Finalize: s.url + "/finalize/1"}
+	for _, host := range s.hosts {
+		o.Authorizations = append(o.Authorizations, s.url+"/authz/"+host)
+		if s.authzs[host] != "valid" {
+			o.Status = "pending"
+		}
+	}
+	if s.cert != nil {
+		o.Status = "valid"
+		o.Certificate = s.url + "/cert/1"
+	}
+	return o
+}
+
+func TestACMEManager(t *testing.T) {
+	defer func(d time.Duration) { acmePollInterval = d }(acmePollInterval)
+	acmePollInterval = 10 * time.Millisecond
+
+	ca, srv := newACMEServer(t)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newManager := func() *acmeManager {
+		return &acmeManager{
+			DirectoryURL: srv.URL + "/dir",
+			Email:        "ops@example.com",
+			Hosts:        []string{"resize.example.com", "ec2.example.com"},
+			CacheDir:     dir,
+		}
+	}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://resize.example.com/", http.StatusMovedPermanently)
+	})
+	// challenges are answered by whichever manager is running
+	var m *acmeManager
+	challenges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.HTTPHandler(fallback).ServeHTTP(w, r)
+	}))
+	defer challenges.Close()
+	m = newManager()
+	ca.challenge = challenges.URL
+
+	if _, err := m.GetCertificate(nil); err == nil {
+		t.Errorf("expected an error before a certificate is obtained")
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range m.Hosts {
+		if err := cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("certificate does not cover %s: %v", host, err)
+		}
+	}
+	if len(m.tokens) != 0 {
+		t.Errorf("expected challenge tokens to be removed, got %v", m.tokens)
+	}
+
+	// requests other than challenges fall through to the redirect
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(challenges.URL + "/instance/i-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("expected a redirect, got %s", resp.Status)
+	}
+	resp, err = client.Get(challenges.URL + acmeChallengePath + "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected unknown tokens to 404, got %s", resp.Status)
+	}
+
+	// a restart uses the cached certificate
+	m = newManager()
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if ca.orders != 1 {
+		t.Errorf("expected the cached certificate to be used, got %d orders", ca.orders)
+	}
+
+	// a certificate close to expiry is renewed with the cached account key
+	m = newManager()
+	m.RenewBefore = 365 * 24 * time.Hour
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if ca.orders != 2 {
+		t.Errorf("expected the certificate to be renewed, got %d orders", ca.orders)
+	}
+	if len(ca.accounts) != 1 {
+		t.Errorf("expected the account key to be reused, got %d accounts", len(ca.accounts))
+	}
+}
+
+func TestACMEClientTimeout(t *testing.T) {
+	client, err := acmeClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == http.DefaultClient || client.Timeout != acmeTimeout {
+		t.Errorf("expected a client with a %s timeout, got %+v", acmeTimeout, client)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"io"
 	"log"
+	"log/syslog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/yhat/middleware"
@@ -23,27 +28,184 @@ func main() {
 	tlsCert := flag.String("tlscert", "", "cert.crt file for TLS")
 	tlsKey := flag.String("tlskey", "", "cert.key file for TLS")
 
-	public := flag.String("public", "./public", "`path` of the directory holding static content")
-	templates := flag.String("templates", "./templates", "`path` of the directory holding app templates")
-	reloadTmpl := flag.Bool("reload-templates", false, "should the app recompile templates on each request")
+	canonicalHost := flag.String("canonical-host", "", "`host[:port]` HTTP requests are redirected to, defaults to the host requested on the -https port")
+	hstsMaxAge := flag.Duration("hsts-max-age", 365*24*time.Hour, "max-age of the Strict-Transport-Security header sent over HTTPS, 0 disables it")
+
+	forceHTTPS := flag.Bool("force-https", false, "redirect HTTP requests to HTTPS when only -http is served, such as behind a TLS terminating load balancer")
+
+	basePath := flag.String("base-path", "", "`path` the app is mounted at behind a reverse proxy, such as /tools/resize")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated addresses or CIDR networks of reverse proxies whose X-Forwarded-For, -Proto and -Host headers are trusted")
+
+	acmeDomains := flag.String("acme-domains", "", "comma separated host names to obtain a TLS certificate for from an ACME CA, instead of -tlscert and -tlskey")
+	acmeEmail := flag.String("acme-email", "", "contact email for the ACME account")
+	acmeDirectory := flag.String("acme-directory", letsEncryptURL, "directory URL of the ACME CA")
+	acmeCache := flag.String("acme-cache", "./acme", "`path` of the directory caching the ACME account key and certificate")
+	acmeCA := flag.String("acme-ca", "", "PEM `file` of extra root certificates trusted when talking to the ACME CA, such as a test CA's")
+
+	public := flag.String("public", "", "`path` of a directory holding static content, overriding the embedded copy")
+	templates := flag.String("templates", "", "`path` of a directory holding app templates, overriding the embedded copy")
+	reloadTmpl := flag.Bool("reload-templates", false, "should the app recompile templates on each request, reads ./templates and ./public unless overridden")
 
 	sessionkey := flag.String("sessionkey", "", "secret key for session cookies")
+	sessionTimeout := flag.Duration("session-timeout", 12*time.Hour, "how long a login lasts, however active the session")
+	idleTimeout := flag.Duration("idle-timeout", time.Hour, "how long a session may go unused before it ends")
+	admins := flag.String("admins", "", "comma separated access key IDs of users who may view and revoke every user's sessions")
 
 	accessLog := flag.String("accesslog", "", "file for access log")
+	auditLog := flag.String("auditlog", "", "file for a JSON audit log of changes made to AWS, or 'syslog'")
+
+	origins := flag.String("origins", "", "comma separated list of origins allowed to open websockets, defaults to the request host")
+
+	pricingFile := flag.String("pricing-file", "", "local copy of the EC2 offer file used to estimate costs")
+	pricingURL := flag.String("pricing-url", resize.DefaultPricingURL, "URL of the EC2 offer file, downloaded when pricing is refreshed")
+	refreshPricing := flag.Bool("refresh-pricing", false, "download the EC2 offer file to -pricing-file on startup")
+
+	recommend := flag.Bool("recommend", false, "show rightsizing recommendations on instance pages")
+	cloudWatch := flag.String("cloudwatch-endpoint", "", "override the CloudWatch endpoint used for utilization data")
+
+	snapshotRetention := flag.Duration("snapshot-retention", 7*24*time.Hour, "age after which pre-resize snapshots are offered for cleanup")
+
+	approvalTag := flag.String("approval-tag", "", "tag rule, `key[=value]`, for instances whose resizes must be approved by a second user")
+	approvalTTL := flag.Duration("approval-ttl", 24*time.Hour, "how long approval requests stay open")
+	approvalWebhook := flag.String("approval-webhook", "", "URL to POST new approval requests to")
+
+	protectedTag := flag.String("protected-tag", "", "tag rule, `key[=value]`, for instances which cannot be stopped or terminated")
+	requiredTags := flag.String("required-tags", "", "comma separated tag rules, `key[=value],...`, instances are warned about if missing")
+
+	regionsFile := flag.String("regions-file", "", "INI file of additional regions with custom EC2 endpoints")
+	providersFile := flag.String("providers-file", "", "INI file of EC2-compatible private clouds users can log into")
+
+	readyEndpoint := flag.String("ready-endpoint", "", "URL, such as an EC2 endpoint, which must be reachable for /readyz to report ready")
+
+	configFile := flag.String("config", "", "INI `file` of settings named after these flags; flags and "+envPrefix+"* environment variables take precedence")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
 
 	flag.Parse()
 
+	if *configFile == "" {
+		*configFile = os.Getenv(envName("config"))
+	}
+	if err := loadConfig(flag.CommandLine, *configFile, os.Getenv); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateConfig(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		if err := writeConfig(os.Stdout, flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var store *sessions.CookieStore
 	if *sessionkey != "" {
 		store = sessions.NewCookieStore([]byte(*sessionkey))
 	}
 
-	app, err := resize.NewApp(*public, *templates, store)
+	// the embedded assets are used unless directories on disk are given,
+	// or templates are reloaded during development
+	if *reloadTmpl {
+		if *public == "" {
+			*public = "./public"
+		}
+		if *templates == "" {
+			*templates = "./templates"
+		}
+	}
+	staticFS, tmplFS, err := assetFS(*public, *templates)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app, err := resize.NewAppFS(staticFS, tmplFS, store)
 	if err != nil {
 		log.Fatal(err)
 	}
 	app.ReloadTemplates = *reloadTmpl
-	h := middleware.GZip(app)
+	app.BasePath = strings.TrimSuffix(*basePath, "/")
+	if *origins != "" {
+		app.AllowedOrigins = strings.Split(*origins, ",")
+	}
+	if *approvalTag != "" {
+		rule, err := resize.ParseTagRule(*approvalTag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.ApprovalRule = &rule
+	}
+	if *protectedTag != "" {
+		rule, err := resize.ParseTagRule(*protectedTag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.ProtectedRule = &rule
+	}
+	if *requiredTags != "" {
+		for _, s := range strings.Split(*requiredTags, ",") {
+			rule, err := resize.ParseTagRule(s)
+			if err != nil {
+				log.Fatal(err)
+			}
+			app.RequiredTags = append(app.RequiredTags, rule)
+		}
+	}
+	if *regionsFile != "" {
+		regions, err := resize.LoadRegions(*regionsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Regions = regions
+	}
+	if *providersFile != "" {
+		providers, err := resize.LoadProviders(*providersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Providers = providers
+	}
+	if *admins != "" {
+		app.Admins = strings.Split(*admins, ",")
+	}
+	app.SessionTimeout = *sessionTimeout
+	app.IdleTimeout = *idleTimeout
+	app.ApprovalTTL = *approvalTTL
+	app.SnapshotRetention = *snapshotRetention
+	app.Recommend = *recommend
+	app.CloudWatchEndpoint = *cloudWatch
+	app.ReadyEndpoint = *readyEndpoint
+	app.PricingFile = *pricingFile
+	app.PricingURL = *pricingURL
+	switch {
+	case *refreshPricing:
+		if err := app.RefreshPricing(); err != nil {
+			log.Fatal(err)
+		}
+	case *pricingFile != "":
+		// costs are optional, run without them if the file is unusable
+		if err := app.LoadPricingFile(); err != nil {
+			log.Printf("could not load pricing data: %v", err)
+		}
+	}
+	app.LoadInstanceTypes()
+	if *approvalWebhook != "" {
+		app.Notifier = &resize.WebhookNotifier{URL: *approvalWebhook}
+	}
+	switch *auditLog {
+	case "":
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "resize")
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.AuditLog = w
+	default:
+		file, err := os.OpenFile(*auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.AuditLog = file
+	}
+	h := secureHeaders(middleware.GZip(app), *hstsMaxAge)
 
 	var logDest io.Writer
 	if *accessLog == "" {
@@ -55,29 +217,79 @@ func main() {
 		}
 		logDest = file
 	}
-	h = middleware.Log(logDest, h)
+	var trusted []*net.IPNet
+	if *trustedProxies != "" {
+		trusted, err = parseCIDRs(*trustedProxies)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// health checks skip the access log and any HTTPS redirect, as load
+	// balancers poll them on whichever listener they are configured with
+	probes := proxyHeaders(h, trusted)
+	serve := func(h http.Handler) http.Handler {
+		logged := middleware.Log(logDest, proxyHeaders(h, trusted))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.IsProbe(r) {
+				probes.ServeHTTP(w, r)
+				return
+			}
+			logged.ServeHTTP(w, r)
+		})
+	}
 
 	httpURL := (&url.URL{Scheme: "http", Host: expandHost(*httpAddr), Path: "/"}).String()
 
 	if *httpsAddr == "" {
+		if *forceHTTPS {
+			h = requireHTTPS(h, redirectHTTPS(*canonicalHost, ""))
+		}
 		log.Println("listening on " + httpURL)
-		log.Fatal(http.ListenAndServe(*httpAddr, h))
+		log.Fatal(http.ListenAndServe(*httpAddr, serve(h)))
 	}
 
 	httpsURL := (&url.URL{Scheme: "https", Host: expandHost(*httpsAddr), Path: "/"}).String()
 
-	// redirect all HTTP requests to HTTPS
-	redirect := func(w http.ResponseWriter, r *http.Request) {
-		to := (&url.URL{Scheme: "https", Host: expandHost(*httpsAddr), Path: r.URL.Path}).String()
-		http.Redirect(w, r, to, http.StatusMovedPermanently)
+	// redirect HTTP requests to HTTPS, unless a trusted proxy received them
+	// over HTTPS. ACME HTTP-01 challenges are answered on the HTTP listener,
+	// which must be listening before a certificate is requested
+	httpHandler := requireHTTPS(h, redirectHTTPS(*canonicalHost, *httpsAddr))
+	var acme *acmeManager
+	if *acmeDomains != "" {
+		client, err := acmeClient(*acmeCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		acme = &acmeManager{
+			DirectoryURL: *acmeDirectory,
+			Email:        *acmeEmail,
+			Hosts:        strings.Split(*acmeDomains, ","),
+			CacheDir:     *acmeCache,
+			Client:       client,
+		}
+		httpHandler = acme.HTTPHandler(httpHandler)
+	}
+	ln, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		log.Fatal(err)
 	}
-
 	go func() {
-		log.Fatal(http.ListenAndServe(*httpAddr, http.HandlerFunc(redirect)))
+		log.Fatal(http.Serve(ln, serve(httpHandler)))
 	}()
 
+	server := &http.Server{Addr: *httpsAddr, Handler: serve(h)}
+	certFile, keyFile := *tlsCert, *tlsKey
+	if acme != nil {
+		if err := acme.Start(); err != nil {
+			log.Fatalf("obtaining certificate: %v", err)
+		}
+		go acme.renewLoop()
+		server.TLSConfig = &tls.Config{GetCertificate: acme.GetCertificate}
+		certFile, keyFile = "", ""
+	}
+
 	log.Println("listening on " + httpsURL)
-	log.Fatal(http.ListenAndServeTLS(*httpsAddr, *tlsCert, *tlsKey, h))
+	log.Fatal(server.ListenAndServeTLS(certFile, keyFile))
 }
 
 // expand ':4040' to '0.0.0.0:4040'
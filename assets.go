@@ -0,0 +1,34 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// assets holds the templates and static content compiled into the binary.
+//
+//go:embed templates public
+var assets embed.FS
+
+// assetFS returns the file systems holding static content and templates.
+// Each is read from the named directory on disk if one is given, otherwise
+// from the copy embedded in the binary.
+func assetFS(public, templates string) (static, tmpl fs.FS, err error) {
+	static, err = dirOrEmbedded(public, "public")
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err = dirOrEmbedded(templates, "templates")
+	if err != nil {
+		return nil, nil, err
+	}
+	return static, tmpl, nil
+}
+
+func dirOrEmbedded(dir, embedded string) (fs.FS, error) {
+	if dir != "" {
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(assets, embedded)
+}
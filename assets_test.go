@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yhat/resize/resize"
+)
+
+func TestEmbeddedAssets(t *testing.T) {
+	static, tmpl, err := assetFS("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := resize.NewAppFS(static, tmpl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/login", "Access Key ID"},
+		{"/js/global.js", "WebSocket"},
+		{"/css/bootstrap.min.css", "Bootstrap"},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(s.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200, got %s", test.path, resp.Status)
+		}
+		if !strings.Contains(string(body), test.want) {
+			t.Errorf("%s: expected body to contain %q", test.path, test.want)
+		}
+	}
+	resp, err := http.Get(s.URL + "/favicon.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/favicon.ico: expected 200, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vaughan0/go-ini"
+	"github.com/yhat/resize/resize"
+)
+
+// envPrefix is prepended to the environment variable for each flag, for
+// example RESIZE_SESSIONKEY sets -sessionkey.
+const envPrefix = "RESIZE_"
+
+// secretFlags are redacted when the configuration is printed.
+var secretFlags = map[string]bool{
+	"sessionkey": true,
+}
+
+// secretURLFlags are URLs which may carry a secret, such as a webhook token,
+// in their credentials, path or query. Only their scheme and host are
+// printed.
+var secretURLFlags = map[string]bool{
+	"approval-webhook": true,
+}
+
+// metaFlags control how the configuration is loaded and cannot themselves be
+// set from it.
+var metaFlags = map[string]bool{
+	"config":       true,
+	"print-config": true,
+}
+
+// envName returns the environment variable which sets a flag.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadConfig sets the flags which were not given on the command line from
+// environment variables or, failing that, from the INI config file, whose
+// top level keys are flag names. If filename is empty only the environment
+// is used.
+func loadConfig(fs *flag.FlagSet, filename string, getenv func(string) string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	file := make(ini.File)
+	if filename != "" {
+		if err := file.LoadFile(filename); err != nil {
+			return fmt.Errorf("reading config file: %v", err)
+		}
+	}
+	for name := range file {
+		if name != "" {
+			return fmt.Errorf("%s: unexpected section [%s]", filename, name)
+		}
+	}
+	for key := range file[""] {
+		if fs.Lookup(key) == nil || metaFlags[key] {
+			return fmt.Errorf("%s: unknown setting %q", filename, key)
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || metaFlags[f.Name] {
+			return
+		}
+		if value := getenv(envName(f.Name)); value != "" {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("%s: invalid value %q: %v", envName(f.Name), value, e)
+			}
+			return
+		}
+		if value, ok := file.Get("", f.Name); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("%s: invalid value %q for %s: %v", filename, value, f.Name, e)
+			}
+		}
+	})
+	return err
+}
+
+// validateConfig checks the effective configuration, reporting every
+// problem found.
+func validateConfig(fs *flag.FlagSet) error {
+	get := func(name string) string { return fs.Lookup(name).Value.String() }
+	var problems []string
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	acme := get("acme-domains") != ""
+	if get("https") != "" {
+		switch {
+		case acme && (get("tlscert") != "" || get("tlskey") != ""):
+			add("-acme-domains cannot be used with -tlscert and -tlskey")
+		case !acme && (get("tlscert") == "" || get("tlskey") == ""):
+			add("-https requires -tlscert and -tlskey, or -acme-domains")
+		}
+		if get("https") == get("http") {
+			add("-http and -https cannot both listen on %s", get("http"))
+		}
+	} else if get("tlscert") != "" || get("tlskey") != "" {
+		add("-tlscert and -tlskey require -https")
+	} else if acme {
+		add("-acme-domains requires -https")
+	}
+	if host := get("canonical-host"); host != "" {
+		if u, err := url.Parse("https://" + host); err != nil || u.Host != host {
+			add("-canonical-host: invalid host %q", host)
+		}
+	}
+	if p := get("base-path"); p != "" {
+		if p[0] != '/' || path.Clean(p) != strings.TrimSuffix(p, "/") || strings.ContainsAny(p, "?#") {
+			add("-base-path: invalid path %q", p)
+		}
+	}
+	if proxies := get("trusted-proxies"); proxies != "" {
+		if _, err := parseCIDRs(proxies); err != nil {
+			add("-trusted-proxies: %v", err)
+		}
+	}
+	if acme {
+		for _, host := range strings.Split(get("acme-domains"), ",") {
+			if host == "" || strings.ContainsAny(host, ":/ ") {
+				add("-acme-domains: invalid host name %q", host)
+			}
+		}
+	}
+	sessionTimeout, _ := time.ParseDuration(get("session-timeout"))
+	idleTimeout, _ := time.ParseDuration(get("idle-timeout"))
+	if sessionTimeout > 0 && idleTimeout > sessionTimeout {
+		add("-idle-timeout %s is longer than -session-timeout %s", idleTimeout, sessionTimeout)
+	}
+	if origins := get("origins"); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+				add("-origins: invalid origin %q", origin)
+			}
+		}
+	}
+	for _, name := range []string{"approval-webhook", "pricing-url", "cloudwatch-endpoint", "acme-directory", "ready-endpoint"} {
+		if value := get(name); value != "" {
+			if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("-%s: invalid URL %q", name, value)
+			}
+		}
+	}
+	var rules []string
+	for _, name := range []string{"approval-tag", "protected-tag"} {
+		if value := get(name); value != "" {
+			rules = append(rules, value)
+		}
+	}
+	if value := get("required-tags"); value != "" {
+		rules = append(rules, strings.Split(value, ",")...)
+	}
+	for _, rule := range rules {
+		if _, err := resize.ParseTagRule(rule); err != nil {
+			add("invalid tag rule: %v", err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// writeConfig writes the effective configuration in the config file format,
+// redacting secrets.
+func writeConfig(w io.Writer, fs *flag.FlagSet) error {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !metaFlags[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		value := fs.Lookup(name).Value.String()
+		if secretFlags[name] && value != "" {
+			value = "REDACTED"
+		}
+		if secretURLFlags[name] && value != "" {
+			value = redactURL(value)
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s\n", name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactURL replaces everything but the scheme and host of a URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "REDACTED"
+	}
+	redacted := url.URL{Scheme: u.Scheme, Host: u.Host}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		redacted.Path = "/REDACTED"
+	}
+	return redacted.String()
+}
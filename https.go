@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yhat/resize/resize"
+)
+
+// contentSecurityPolicy allows the app's own assets plus what the templates
+// need: jQuery from Google's CDN, inline styles, the Google Fonts which
+// Bootstrap imports, and websockets back to the app. Scripts must live in
+// public/js, none are allowed inline.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' ajax.googleapis.com; " +
+	"style-src 'self' 'unsafe-inline' fonts.googleapis.com; " +
+	"font-src 'self' fonts.gstatic.com; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// secureHeaders sets browser security headers on every response. HSTS is
+// only sent over HTTPS, as browsers ignore it otherwise, and is disabled
+// when hstsMaxAge is zero.
+func secureHeaders(h http.Handler, hstsMaxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "same-origin")
+		if resize.IsHTTPS(r) && hstsMaxAge > 0 {
+			header.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int64(hstsMaxAge/time.Second)))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// redirectHTTPS redirects requests to the same path and query over HTTPS.
+// The target host is canonicalHost if given, otherwise the host the request
+// was made to, on the port of httpsAddr.
+func redirectHTTPS(canonicalHost, httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := canonicalHost
+		if host == "" {
+			host = r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			switch {
+			case host == "":
+				host = expandHost(httpsAddr)
+			case port != "" && port != "443":
+				host = net.JoinHostPort(host, port)
+			}
+		}
+		to := &url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+		http.Redirect(w, r, to.String(), http.StatusMovedPermanently)
+	})
+}
+
+// requireHTTPS serves requests made over HTTPS, including those a trusted
+// proxy received over HTTPS, with h and redirects all others.
+func requireHTTPS(h, redirect http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if resize.IsHTTPS(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		redirect.ServeHTTP(w, r)
+	})
+}
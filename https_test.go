@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		canonical string
+		httpsAddr string
+		url       string
+		host      string
+		expected  string
+	}{
+		{"", ":443", "/instance/i-1?status=running", "resize.example.com", "https://resize.example.com/instance/i-1?status=running"},
+		{"", ":443", "/", "resize.example.com:80", "https://resize.example.com/"},
+		{"", ":8443", "/about", "localhost:4040", "https://localhost:8443/about"},
+		{"", ":8443", "/about", "", "https://0.0.0.0:8443/about"},
+		{"resize.example.com", ":443", "/?q=web", "10.0.0.1", "https://resize.example.com/?q=web"},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		redirectHTTPS(test.canonical, test.httpsAddr).ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: expected status 301, got %d", test.url, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != test.expected {
+			t.Errorf("%s on %s: expected redirect to %s, got %s", test.url, test.host, test.expected, got)
+		}
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := secureHeaders(ok, time.Hour)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	for _, name := range []string{"Content-Security-Policy", "X-Frame-Options", "X-Content-Type-Options", "Referrer-Policy"} {
+		if w.Header().Get(name) == "" {
+			t.Errorf("expected %s header", name)
+		}
+	}
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("expected no HSTS header over HTTP, got %q", hsts)
+	}
+	for _, directive := range strings.Split(w.Header().Get("Content-Security-Policy"), ";") {
+		if fields := strings.Fields(directive); len(fields) > 0 && fields[0] == "script-src" &&
+			strings.Contains(directive, "'unsafe-inline'") {
+			t.Errorf("expected inline scripts to be disallowed, got %q", directive)
+		}
+	}
+
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=3600" {
+		t.Errorf("expected HSTS max-age=3600, got %q", hsts)
+	}
+
+	w = httptest.NewRecorder()
+	secureHeaders(ok, 0).ServeHTTP(w, r)
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("expected HSTS to be disabled, got %q", hsts)
+	}
+}
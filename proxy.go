@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseCIDRs parses a comma separated list of networks. A plain IP address
+// is a network of that address alone.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trustedIP(trusted []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyHeaders applies the X-Forwarded-For, X-Forwarded-Proto and
+// X-Forwarded-Host headers of requests sent by trusted proxies, so the app
+// sees the client's address, the scheme it used, as the URL scheme, and the
+// host it requested. The headers of other requests are ignored.
+func proxyHeaders(h http.Handler, trusted []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !trustedIP(trusted, host) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		req := new(http.Request)
+		*req = *r
+		req.URL = new(url.URL)
+		*req.URL = *r.URL
+
+		// the client is the last address not added by a trusted proxy
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			client := strings.TrimSpace(addrs[0])
+			for i := len(addrs) - 1; i >= 0; i-- {
+				if addr := strings.TrimSpace(addrs[i]); !trustedIP(trusted, addr) {
+					client = addr
+					break
+				}
+			}
+			if ip := net.ParseIP(client); ip != nil {
+				req.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+			}
+		}
+		switch proto := strings.ToLower(firstValue(r.Header.Get("X-Forwarded-Proto"))); proto {
+		case "http", "https":
+			req.URL.Scheme = proto
+		}
+		if forwardedHost := firstValue(r.Header.Get("X-Forwarded-Host")); forwardedHost != "" {
+			req.Host = forwardedHost
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// firstValue returns the first of a comma separated list of header values,
+// the one set by the proxy closest to the client.
+func firstValue(s string) string {
+	if i := strings.Index(s, ","); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
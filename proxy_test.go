@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs("10.0.0.0/8, 127.0.0.1,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("expected 3 networks, got %d", len(nets))
+	}
+	for _, addr := range []string{"10.1.2.3", "127.0.0.1", "::1"} {
+		if !trustedIP(nets, addr) {
+			t.Errorf("expected %s to be trusted", addr)
+		}
+	}
+	for _, addr := range []string{"127.0.0.2", "192.168.0.1", "not-an-ip"} {
+		if trustedIP(nets, addr) {
+			t.Errorf("expected %s not to be trusted", addr)
+		}
+	}
+	if _, err := parseCIDRs("10.0.0.0/8,proxy"); err == nil {
+		t.Error("expected an error parsing an invalid network")
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *http.Request
+	h := proxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}), trusted)
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		proto      string
+		host       string
+
+		expectedAddr   string
+		expectedScheme string
+		expectedHost   string
+	}{
+		// requests from trusted proxies use the forwarded values
+		{"10.0.0.1:5000", "203.0.113.9", "https", "resize.example.com", "203.0.113.9:0", "https", "resize.example.com"},
+		// the client is the last address not added by a trusted proxy
+		{"10.0.0.1:5000", "198.51.100.1, 203.0.113.9, 10.0.0.2", "http", "", "203.0.113.9:0", "http", "app:4040"},
+		// headers from other clients are ignored
+		{"203.0.113.9:5000", "198.51.100.1", "https", "evil.example.com", "203.0.113.9:5000", "", "app:4040"},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/instance/i-1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Host = "app:4040"
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-For", test.forwarded)
+		r.Header.Set("X-Forwarded-Proto", test.proto)
+		if test.host != "" {
+			r.Header.Set("X-Forwarded-Host", test.host)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got.RemoteAddr != test.expectedAddr {
+			t.Errorf("%s: expected remote address %s, got %s", test.remoteAddr, test.expectedAddr, got.RemoteAddr)
+		}
+		if got.URL.Scheme != test.expectedScheme {
+			t.Errorf("%s: expected scheme %q, got %q", test.remoteAddr, test.expectedScheme, got.URL.Scheme)
+		}
+		if got.Host != test.expectedHost {
+			t.Errorf("%s: expected host %s, got %s", test.remoteAddr, test.expectedHost, got.Host)
+		}
+		if r.URL.Scheme != "" {
+			t.Errorf("%s: original request was modified", test.remoteAddr)
+		}
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := proxyHeaders(requireHTTPS(ok, redirectHTTPS("", "")), trusted)
+
+	tests := []struct {
+		remoteAddr string
+		proto      string
+		status     int
+	}{
+		{"10.0.0.1:5000", "https", http.StatusOK},
+		{"10.0.0.1:5000", "http", http.StatusMovedPermanently},
+		{"10.0.0.2:5000", "https", http.StatusMovedPermanently},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/tools/resize/?q=web", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Host = "resize.example.com"
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-Proto", test.proto)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s over %s: expected status %d, got %d", test.remoteAddr, test.proto, test.status, w.Code)
+		}
+		if w.Code == http.StatusMovedPermanently {
+			if loc := w.Header().Get("Location"); loc != "https://resize.example.com/tools/resize/?q=web" {
+				t.Errorf("%s over %s: unexpected redirect to %s", test.remoteAddr, test.proto, loc)
+			}
+		}
+	}
+}
@@ -1,3 +1,7 @@
+$.ajaxSetup({
+    headers: { "X-CSRF-Token": $('meta[name="csrf-token"]').attr("content") }
+});
+
 $(function() {
     if ( $("#awsRegion").length ) {
         $("#awsRegion").on("change", function() {
@@ -5,9 +9,9 @@ $(function() {
             formData["region"] = this.value;
 
             $('#instances').addClass('disabled-div');
-            $.post("/region", formData)
+            $.post("region", formData)
             .success(function (data) {
-                window.location.href = "/";
+                window.location.href = "./";
             })
             .fail(function(xhr, textStatus, errorThrown) {
                 alert(xhr.reponseText);
@@ -27,7 +31,7 @@ $(function() {
         e.preventDefault();
         var wsScheme = "";
         if (scheme == "https:") {
-            wsScheme += "ws:"
+            wsScheme += "wss:"
         } else {
             wsScheme += "ws:"
         }
@@ -35,8 +39,44 @@ $(function() {
         var $form = $(this);
 
         var wsUrl = $form.prop('action').replace(scheme, wsScheme),
-            newVal = $form.find('option:selected').val(),
-            ws = new WebSocket(wsUrl);
+            newVal = $form.find('select').first().val();
+
+        if ($form.attr('id') == 'resize') {
+            newVal = JSON.stringify({
+                Type: newVal,
+                Snapshot: $form.find('[name="snapshot"]').val(),
+                Image: $form.find('[name="image"]').is(':checked')
+            });
+        }
+
+        if ($form.attr('id') == 'grow-volume') {
+            newVal = JSON.stringify({
+                VolumeId: newVal,
+                Size: parseInt($form.find('[name="size"]').val(), 10),
+                VolumeType: $form.find('[name="volume-type"]').val(),
+                IOPS: parseInt($form.find('[name="iops"]').val(), 10) || 0
+            });
+        }
+
+        if ($form.attr('id') == 'lifecycle') {
+            var instanceId = $form.data('instance'),
+                confirmation = "";
+            if (newVal == "terminate") {
+                confirmation = prompt("Terminating " + instanceId + " cannot be undone. " +
+                    "Type the instance ID to confirm.");
+                if (confirmation != instanceId) {
+                    return;
+                }
+            } else if (!confirm("Are you sure you want to " + newVal + " " + instanceId + "?")) {
+                return;
+            }
+            newVal = JSON.stringify({
+                Action: newVal,
+                Confirm: confirmation
+            });
+        }
+
+        var ws = new WebSocket(wsUrl);
 
         ws.onopen = function() {
             ws.send(newVal);
@@ -55,8 +95,22 @@ $(function() {
                 $('#status-msg')
                     .css("color", '#e51c23')
                     .text(ev.Message);
+                if (ev.JobId) {
+                    $('#status-msg').append(' ', $('<a>')
+                        .attr('href', 'jobs/' + ev.JobId)
+                        .text('Recover from pre-resize AMI'));
+                }
+                $('.change-instance-form').removeClass('disabled-div');
+                break;
+            case "pending":
+                $('#status-msg')
+                    .css("color", '#f0ad4e')
+                    .text(ev.Message);
                 $('.change-instance-form').removeClass('disabled-div');
                 break;
+            case "progress":
+                $('#status-msg').text(ev.Message);
+                break;
             case "message":
                 var $instanceState = $('#instance-state');
                 $instanceState
@@ -70,6 +124,29 @@ $(function() {
         }
     });
 
+    var hoursPerMonth = 730;
+
+    function showCost() {
+        var $cost = $('#cost');
+        if (!$cost.length) {
+            return;
+        }
+        var current = parseFloat($cost.data('hourly')),
+            proposed = parseFloat($('#change-type option:selected').data('hourly'));
+        if (isNaN(proposed)) {
+            $('#proposed-hourly, #proposed-monthly, #delta-hourly, #delta-monthly').text('unknown');
+            return;
+        }
+        var delta = proposed - current,
+            sign = delta < 0 ? '-$' : '+$';
+        $('#proposed-hourly').text('$' + proposed.toFixed(4));
+        $('#proposed-monthly').text('$' + (proposed * hoursPerMonth).toFixed(2));
+        $('#delta-hourly').text(sign + Math.abs(delta).toFixed(4));
+        $('#delta-monthly').text(sign + Math.abs(delta * hoursPerMonth).toFixed(2));
+    }
+    $('#change-type').on('change', showCost);
+    showCost();
+
     function colorForState(state) {
         switch(state) {
             case "running":
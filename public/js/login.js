@@ -0,0 +1,18 @@
+$(function() {
+    $("#loginForm").submit(function(e) {
+
+        var formData = {};
+
+        formData["accessKey"] = $("#accessKey").val();
+        formData["secretKey"] = $("#secretKey").val();
+        formData["provider"] = $("#provider").val() || "";
+
+        $.post("login", formData)
+        .success(function (data) { window.location.href = "./"; })
+        .fail(function(xhr, textStatus, errorThrown) {
+            $("#alert").text(xhr.responseText);
+            $("#alert-group").show();
+        });
+        return false;
+    });
+})
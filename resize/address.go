@@ -0,0 +1,251 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// addressId identifies an Elastic IP. VPC addresses are identified by their
+// allocation ID, EC2-Classic addresses only by their public IP.
+func addressId(addr ec2.Address) string {
+	if addr.AllocationId != "" {
+		return addr.AllocationId
+	}
+	return addr.PublicIp
+}
+
+// findAddress looks up an Elastic IP by allocation ID or public IP.
+func findAddress(ec2Cli *ec2.EC2, id string) (ec2.Address, error) {
+	var resp *ec2.DescribeAddressesResp
+	var err error
+	if strings.HasPrefix(id, "eipalloc-") {
+		resp, err = ec2Cli.Addresses(nil, []string{id}, nil)
+	} else {
+		resp, err = ec2Cli.Addresses([]string{id}, nil, nil)
+	}
+	if err != nil {
+		return ec2.Address{}, fmt.Errorf("error getting address %s: %v", id, err)
+	}
+	if len(resp.Addresses) != 1 {
+		return ec2.Address{}, fmt.Errorf("address %s not found", id)
+	}
+	return resp.Addresses[0], nil
+}
+
+// associateAddress associates an Elastic IP with an instance. Running
+// instances do not need to be stopped. If move is true an address already
+// associated with another instance is moved.
+func associateAddress(ec2Cli *ec2.EC2, addr ec2.Address, instanceId string, move bool) error {
+	if addr.InstanceId != "" && !move {
+		return fmt.Errorf("%s is already associated with %s", addr.PublicIp, addr.InstanceId)
+	}
+	opts := &ec2.AssociateAddress{
+		InstanceId:         instanceId,
+		AllocationId:       addr.AllocationId,
+		AllowReassociation: move,
+	}
+	if addr.AllocationId == "" {
+		opts.PublicIp = addr.PublicIp
+	}
+	resp, err := ec2Cli.AssociateAddress(opts)
+	if err != nil {
+		return fmt.Errorf("could not associate address: %v", err)
+	}
+	if !resp.Return {
+		return fmt.Errorf("bad response from AWS")
+	}
+	return nil
+}
+
+// disassociateAddress removes an Elastic IP from the instance it is
+// associated with.
+func disassociateAddress(ec2Cli *ec2.EC2, addr ec2.Address) error {
+	var err error
+	switch {
+	case addr.AssociationId != "":
+		_, err = ec2Cli.DisassociateAddress(addr.AssociationId)
+	case addr.InstanceId != "":
+		_, err = ec2Cli.DisassociateAddressClassic(addr.PublicIp)
+	default:
+		return fmt.Errorf("%s is not associated with an instance", addr.PublicIp)
+	}
+	if err != nil {
+		return fmt.Errorf("could not disassociate address: %v", err)
+	}
+	return nil
+}
+
+// releaseAddress returns an unassociated Elastic IP to AWS.
+func releaseAddress(ec2Cli *ec2.EC2, addr ec2.Address) error {
+	if addr.InstanceId != "" || addr.AssociationId != "" {
+		return fmt.Errorf("%s must be disassociated before it is released", addr.PublicIp)
+	}
+	var err error
+	if addr.AllocationId != "" {
+		_, err = ec2Cli.ReleaseAddress(addr.AllocationId)
+	} else {
+		_, err = ec2Cli.ReleasePublicAddress(addr.PublicIp)
+	}
+	if err != nil {
+		return fmt.Errorf("could not release address: %v", err)
+	}
+	return nil
+}
+
+// localRedirect returns the form's "next" value if it is a path on this
+// site, otherwise fallback.
+func localRedirect(r *http.Request, fallback string) string {
+	next := r.PostFormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
+
+// Path: /addresses
+func (app *App) handleAddresses(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	resp, err := ec2Cli.Addresses(nil, nil, nil)
+	if notImplemented(err) {
+		app.features.disable(ec2Cli.Region.Name, featureAddresses)
+		app.render(w, r, "addresses.html", map[string]interface{}{"Unsupported": true})
+		return
+	}
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("Bad response from AWS %v", err))
+		return
+	}
+	instResp, err := ec2Cli.Instances(nil, nil)
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("Bad response from AWS %v", err))
+		return
+	}
+	var instances []ec2.Instance
+	for _, instance := range allInstances(instResp) {
+		if instance.State.Name != "terminated" && instance.State.Name != "shutting-down" {
+			instances = append(instances, instance)
+		}
+	}
+	data := map[string]interface{}{
+		"Addresses": resp.Addresses,
+		"Instances": instances,
+	}
+	app.render(w, r, "addresses.html", data)
+}
+
+// Path: /addresses/allocate
+func (app *App) handleAllocateAddress(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	domain := r.PostFormValue("domain")
+	if domain != "vpc" && domain != "standard" {
+		http.Error(w, "Domain must be 'vpc' or 'standard'", http.StatusBadRequest)
+		return
+	}
+	resp, err := ec2Cli.AllocateAddress(&ec2.AllocateAddress{Domain: domain})
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("could not allocate address: %v", err))
+		return
+	}
+	app.record(ec2Cli, HistoryEntry{
+		User:    user(ec2Cli),
+		Region:  ec2Cli.Region.Name,
+		Action:  "address",
+		Message: fmt.Sprintf("allocated %s address %s", domain, resp.PublicIp),
+	})
+	if instanceId := r.PostFormValue("instance"); instanceId != "" {
+		addr := ec2.Address{PublicIp: resp.PublicIp, AllocationId: resp.AllocationId, Domain: resp.Domain}
+		if err := app.changeAddress(ec2Cli, addr, instanceId, "associate"); err != nil {
+			// don't keep paying for an address nobody asked for
+			if rerr := app.changeAddress(ec2Cli, addr, "", "release"); rerr != nil {
+				err = fmt.Errorf("%v, and could not release %s (%s): %v", err, addr.PublicIp, addr.AllocationId, rerr)
+			} else {
+				err = fmt.Errorf("%v, released %s", err, addr.PublicIp)
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	http.Redirect(w, r, app.url(localRedirect(r, "/addresses")), http.StatusSeeOther)
+}
+
+// Path: /addresses/{address}/{action}
+func (app *App) handleAddressAction(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	vars := mux.Vars(r)
+	addr, err := findAddress(ec2Cli, vars["address"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := app.changeAddress(ec2Cli, addr, r.PostFormValue("instance"), vars["action"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, app.url(localRedirect(r, "/addresses")), http.StatusSeeOther)
+}
+
+// changeAddress performs one of the associate, move, disassociate or release
+// actions on an address and records it in the history.
+func (app *App) changeAddress(ec2Cli *ec2.EC2, addr ec2.Address, instanceId, action string) error {
+	var err error
+	var msg string
+	switch action {
+	case "associate", "move":
+		if instanceId == "" {
+			return fmt.Errorf("no instance provided")
+		}
+		err = associateAddress(ec2Cli, addr, instanceId, action == "move")
+		msg = fmt.Sprintf("associated %s with %s", addr.PublicIp, instanceId)
+		if addr.InstanceId != "" {
+			msg = fmt.Sprintf("moved %s from %s to %s", addr.PublicIp, addr.InstanceId, instanceId)
+		}
+	case "disassociate":
+		instanceId = addr.InstanceId
+		err = disassociateAddress(ec2Cli, addr)
+		msg = fmt.Sprintf("disassociated %s from %s", addr.PublicIp, addr.InstanceId)
+	case "release":
+		err = releaseAddress(ec2Cli, addr)
+		msg = fmt.Sprintf("released %s", addr.PublicIp)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+	app.record(ec2Cli, HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     ec2Cli.Region.Name,
+		InstanceId: instanceId,
+		Action:     "address",
+		Message:    msg,
+	})
+	return nil
+}
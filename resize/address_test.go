@@ -0,0 +1,171 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// addressServer is a stand-in for the EC2 address calls which records the
+// parameters of each request.
+type addressServer struct {
+	mu       sync.Mutex
+	requests []url.Values
+}
+
+func (s *addressServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	s.requests = append(s.requests, q)
+	action := q.Get("Action")
+	switch action {
+	case "AllocateAddress":
+		fmt.Fprint(w, `<AllocateAddressResponse><requestId>r</requestId><publicIp>1.2.3.4</publicIp>
+<domain>vpc</domain><allocationId>eipalloc-1</allocationId></AllocateAddressResponse>`)
+	case "AssociateAddress":
+		if q.Get("InstanceId") == "i-missing" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidInstanceID.NotFound</Code><Message>no such instance</Message></Error></Errors></Response>`)
+			return
+		}
+		fmt.Fprint(w, `<AssociateAddressResponse><requestId>r</requestId><return>true</return></AssociateAddressResponse>`)
+	case "DisassociateAddress", "ReleaseAddress":
+		fmt.Fprintf(w, `<%sResponse><requestId>r</requestId><return>true</return></%sResponse>`, action, action)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *addressServer) last() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func TestAddressActions(t *testing.T) {
+	srv := &addressServer{}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	vpc := ec2.Address{PublicIp: "1.2.3.4", AllocationId: "eipalloc-1", Domain: "vpc"}
+	classic := ec2.Address{PublicIp: "5.6.7.8", Domain: "standard"}
+
+	if err := associateAddress(ec2Cli, vpc, "i-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("AllocationId") != "eipalloc-1" || q.Get("PublicIp") != "" || q.Get("AllowReassociation") != "" {
+		t.Errorf("unexpected VPC associate request %v", q)
+	}
+	if err := associateAddress(ec2Cli, classic, "i-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("PublicIp") != "5.6.7.8" || q.Get("AllocationId") != "" {
+		t.Errorf("unexpected classic associate request %v", q)
+	}
+
+	vpc.InstanceId, vpc.AssociationId = "i-1", "eipassoc-1"
+	classic.InstanceId = "i-1"
+	if err := associateAddress(ec2Cli, vpc, "i-2", false); err == nil {
+		t.Errorf("expected error associating an associated address without moving it")
+	}
+	if err := associateAddress(ec2Cli, vpc, "i-2", true); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("AllowReassociation") != "true" || q.Get("InstanceId") != "i-2" {
+		t.Errorf("unexpected move request %v", q)
+	}
+
+	if err := releaseAddress(ec2Cli, vpc); err == nil {
+		t.Errorf("expected error releasing an associated address")
+	}
+	if err := disassociateAddress(ec2Cli, vpc); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("AssociationId") != "eipassoc-1" {
+		t.Errorf("unexpected VPC disassociate request %v", q)
+	}
+	if err := disassociateAddress(ec2Cli, classic); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("PublicIp") != "5.6.7.8" {
+		t.Errorf("unexpected classic disassociate request %v", q)
+	}
+
+	vpc.InstanceId, vpc.AssociationId = "", ""
+	classic.InstanceId = ""
+	if err := disassociateAddress(ec2Cli, vpc); err == nil {
+		t.Errorf("expected error disassociating an unassociated address")
+	}
+	if err := releaseAddress(ec2Cli, vpc); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("AllocationId") != "eipalloc-1" {
+		t.Errorf("unexpected VPC release request %v", q)
+	}
+	if err := releaseAddress(ec2Cli, classic); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.last(); q.Get("PublicIp") != "5.6.7.8" {
+		t.Errorf("unexpected classic release request %v", q)
+	}
+}
+
+func TestAllocateAddress(t *testing.T) {
+	srv := &addressServer{}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := loginSession(t, app, "AKIDALICE", aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	w := session.do("POST", "/addresses/allocate", url.Values{"domain": {"vpc"}, "instance": {"i-1"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect after allocating, got %d: %s", w.Code, w.Body)
+	}
+	if q := srv.last(); q.Get("Action") != "AssociateAddress" || q.Get("InstanceId") != "i-1" {
+		t.Errorf("expected the address to be associated, got %v", q)
+	}
+
+	w = session.do("POST", "/addresses/allocate", url.Values{"domain": {"vpc"}, "instance": {"i-missing"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "released 1.2.3.4") {
+		t.Errorf("expected the association to fail, got %d: %s", w.Code, w.Body)
+	}
+	if q := srv.last(); q.Get("Action") != "ReleaseAddress" || q.Get("AllocationId") != "eipalloc-1" {
+		t.Errorf("expected the address to be released, got %v", q)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/instance/i-1", "/instance/i-1"},
+		{"", "/addresses"},
+		{"//evil.example.com", "/addresses"},
+		{"/\\evil.example.com", "/addresses"},
+		{"http://evil.example.com", "/addresses"},
+	}
+	for _, test := range tests {
+		form := url.Values{"next": {test.next}}
+		r, _ := http.NewRequest("POST", "/addresses/allocate", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if got := localRedirect(r, "/addresses"); got != test.want {
+			t.Errorf("next=%q: expected %q, got %q", test.next, test.want, got)
+		}
+	}
+}
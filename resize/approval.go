@@ -0,0 +1,276 @@
+package resize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// TagRule matches instances by tag. An empty Value matches any instance with
+// a tag named Key.
+type TagRule struct {
+	Key   string
+	Value string
+}
+
+// ParseTagRule parses a rule of the form "key=value" or "key".
+func ParseTagRule(s string) (TagRule, error) {
+	kv := strings.SplitN(s, "=", 2)
+	rule := TagRule{Key: strings.TrimSpace(kv[0])}
+	if rule.Key == "" {
+		return TagRule{}, fmt.Errorf("tag rule %q has no key", s)
+	}
+	if len(kv) == 2 {
+		rule.Value = strings.TrimSpace(kv[1])
+	}
+	return rule, nil
+}
+
+// Match reports if any of the tags satisfy the rule.
+func (rule TagRule) Match(tags []ec2.Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == rule.Key && (rule.Value == "" || tag.Value == rule.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule TagRule) String() string {
+	if rule.Value == "" {
+		return rule.Key
+	}
+	return rule.Key + "=" + rule.Value
+}
+
+type ApprovalState string
+
+const (
+	ApprovalPending  ApprovalState = "pending"
+	ApprovalApproved ApprovalState = "approved"
+	ApprovalRejected ApprovalState = "rejected"
+	ApprovalExpired  ApprovalState = "expired"
+)
+
+// defaultApprovalTTL is used if the App's ApprovalTTL is not set.
+const defaultApprovalTTL = 24 * time.Hour
+
+// ApprovalRequest is a request by one user to resize an instance which must
+// be approved by another user before the resize can run.
+type ApprovalRequest struct {
+	Id         string
+	Account    string
+	Region     string
+	InstanceId string
+	NewType    string
+	// Requester and Approver are IAM principals, so one user cannot approve
+	// their own request by logging in with a second access key.
+	Requester string
+	Approver  string
+	State     ApprovalState
+	Created   time.Time
+	Decided   time.Time
+	// Used is set once an approved request has been consumed by a resize.
+	Used bool
+}
+
+// Notifier informs approvers of a new approval request.
+type Notifier interface {
+	Notify(req ApprovalRequest) error
+}
+
+// WebhookNotifier POSTs approval requests as JSON to a URL.
+type WebhookNotifier struct {
+	URL string
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(req ApprovalRequest) error {
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bad response from webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// approvals holds approval requests in memory, safe for concurrent use.
+type approvals struct {
+	mu   sync.Mutex
+	reqs map[string]*ApprovalRequest
+}
+
+func newApprovals() *approvals {
+	return &approvals{reqs: make(map[string]*ApprovalRequest)}
+}
+
+// expire marks requests older than ttl as expired, and removes requests
+// older than twice the ttl so they are listed for a while after they are
+// closed. The caller must hold the lock.
+func (a *approvals) expire(ttl time.Duration) {
+	now := time.Now()
+	for id, req := range a.reqs {
+		age := now.Sub(req.Created)
+		if age > 2*ttl {
+			delete(a.reqs, id)
+			continue
+		}
+		switch req.State {
+		case ApprovalPending, ApprovalApproved:
+			if !req.Used && age > ttl {
+				req.State = ApprovalExpired
+			}
+		}
+	}
+}
+
+// request returns the account's open request for the resize, creating one
+// if none exists. created reports if a new request was made.
+func (a *approvals) request(ttl time.Duration, account, region, instanceId, newType, user string) (req ApprovalRequest, created bool, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(ttl)
+	for _, r := range a.reqs {
+		if r.State == ApprovalPending && r.Account == account && r.Region == region &&
+			r.InstanceId == instanceId && r.NewType == newType && r.Requester == user {
+			return *r, false, nil
+		}
+	}
+	id, err := newId()
+	if err != nil {
+		return ApprovalRequest{}, false, err
+	}
+	r := &ApprovalRequest{
+		Id:         id,
+		Account:    account,
+		Region:     region,
+		InstanceId: instanceId,
+		NewType:    newType,
+		Requester:  user,
+		State:      ApprovalPending,
+		Created:    time.Now(),
+	}
+	a.reqs[r.Id] = r
+	return *r, true, nil
+}
+
+// decide approves or rejects a pending request of the account. Users may
+// not decide their own requests.
+func (a *approvals) decide(ttl time.Duration, account, id, user string, approve bool) (ApprovalRequest, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(ttl)
+	r, ok := a.reqs[id]
+	if !ok || r.Account != account {
+		return ApprovalRequest{}, fmt.Errorf("no approval request with id %s", id)
+	}
+	if r.State != ApprovalPending {
+		return ApprovalRequest{}, fmt.Errorf("approval request is %s", r.State)
+	}
+	if r.Requester == user {
+		return ApprovalRequest{}, fmt.Errorf("approval requests must be decided by another user")
+	}
+	if approve {
+		r.State = ApprovalApproved
+	} else {
+		r.State = ApprovalRejected
+	}
+	r.Approver = user
+	r.Decided = time.Now()
+	return *r, nil
+}
+
+// find returns an unused approved request of the account for the resize.
+func (a *approvals) find(ttl time.Duration, account, region, instanceId, newType, user string) (ApprovalRequest, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(ttl)
+	for _, r := range a.reqs {
+		if r.State == ApprovalApproved && !r.Used && r.Account == account && r.Region == region &&
+			r.InstanceId == instanceId && r.NewType == newType && r.Requester == user {
+			return *r, true
+		}
+	}
+	return ApprovalRequest{}, false
+}
+
+// consume marks an approved request of the account used, reporting false if
+// it was already used or is no longer approved.
+func (a *approvals) consume(ttl time.Duration, account, id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(ttl)
+	r, ok := a.reqs[id]
+	if !ok || r.Account != account || r.State != ApprovalApproved || r.Used {
+		return false
+	}
+	r.Used = true
+	return true
+}
+
+// list returns the account's requests, newest first. If instanceId is not
+// empty only requests for that instance are returned.
+func (a *approvals) list(ttl time.Duration, account, instanceId string) []ApprovalRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(ttl)
+	reqs := []ApprovalRequest{}
+	for _, r := range a.reqs {
+		if r.Account == account && (instanceId == "" || r.InstanceId == instanceId) {
+			reqs = append(reqs, *r)
+		}
+	}
+	sort.Sort(byCreated(reqs))
+	return reqs
+}
+
+type byCreated []ApprovalRequest
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool { return s[i].Created.After(s[j].Created) }
+
+func (app *App) approvalTTL() time.Duration {
+	if app.ApprovalTTL <= 0 {
+		return defaultApprovalTTL
+	}
+	return app.ApprovalTTL
+}
+
+// requiresApproval reports if resizing the instance must be approved by
+// another user.
+func (app *App) requiresApproval(instance ec2.Instance) bool {
+	return app.ApprovalRule != nil && app.ApprovalRule.Match(instance.Tags)
+}
+
+// notify informs approvers of a request. If the App has no Notifier the
+// request is logged.
+func (app *App) notify(req ApprovalRequest) {
+	if app.Notifier == nil {
+		app.Logf("approval requested: %s wants to resize %s (%s) to %s, see /approvals",
+			req.Requester, req.InstanceId, req.Region, req.NewType)
+		return
+	}
+	if err := app.Notifier.Notify(req); err != nil {
+		app.Logf("could not notify approvers of request %s: %v", req.Id, err)
+	}
+}
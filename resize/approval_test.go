@@ -0,0 +1,112 @@
+package resize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestTagRule(t *testing.T) {
+	tags := []ec2.Tag{{Key: "Name", Value: "db"}, {Key: "env", Value: "production"}}
+	tests := []struct {
+		rule  string
+		match bool
+	}{
+		{"env=production", true},
+		{"env = production", true},
+		{"env=staging", false},
+		{"env", true},
+		{"owner", false},
+	}
+	for _, test := range tests {
+		rule, err := ParseTagRule(test.rule)
+		if err != nil {
+			t.Errorf("parsing %q: %v", test.rule, err)
+			continue
+		}
+		if got := rule.Match(tags); got != test.match {
+			t.Errorf("rule %q: expected match=%t got %t", test.rule, test.match, got)
+		}
+	}
+	if _, err := ParseTagRule("=production"); err == nil {
+		t.Errorf("expected error parsing rule with no key")
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	ttl := time.Hour
+	a := newApprovals()
+
+	req, created, err := a.request(ttl, "111", "us-east-1", "i-1234", "m3.large", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || req.State != ApprovalPending {
+		t.Fatalf("expected new pending request, got created=%t state=%s", created, req.State)
+	}
+	again, created, err := a.request(ttl, "111", "us-east-1", "i-1234", "m3.large", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || again.Id != req.Id {
+		t.Errorf("expected existing request to be returned")
+	}
+
+	if _, ok := a.find(ttl, "111", "us-east-1", "i-1234", "m3.large", "alice"); ok {
+		t.Errorf("pending request should not be usable")
+	}
+	if a.consume(ttl, "111", req.Id) {
+		t.Errorf("pending request should not be consumable")
+	}
+	if _, err := a.decide(ttl, "111", req.Id, "alice", true); err == nil {
+		t.Errorf("requester should not be able to approve their own request")
+	}
+	if _, err := a.decide(ttl, "222", req.Id, "mallory", true); err == nil {
+		t.Errorf("users of another account should not be able to approve the request")
+	}
+	if reqs := a.list(ttl, "222", ""); len(reqs) != 0 {
+		t.Errorf("expected no requests to be listed for another account, got %v", reqs)
+	}
+	if _, err := a.decide(ttl, "111", req.Id, "bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.decide(ttl, "111", req.Id, "carol", false); err == nil {
+		t.Errorf("decided request should not be decided again")
+	}
+	if _, ok := a.find(ttl, "111", "us-east-1", "i-1234", "m3.xlarge", "alice"); ok {
+		t.Errorf("approval should only apply to the requested type")
+	}
+	if found, ok := a.find(ttl, "111", "us-east-1", "i-1234", "m3.large", "alice"); !ok || found.Id != req.Id {
+		t.Errorf("expected approved request to be found")
+	}
+	if a.consume(ttl, "222", req.Id) {
+		t.Errorf("users of another account should not be able to consume the request")
+	}
+	if !a.consume(ttl, "111", req.Id) {
+		t.Errorf("expected approved request to be consumed")
+	}
+	if a.consume(ttl, "111", req.Id) {
+		t.Errorf("approved request should only be consumed once")
+	}
+	if _, ok := a.find(ttl, "111", "us-east-1", "i-1234", "m3.large", "alice"); ok {
+		t.Errorf("used request should not be found")
+	}
+
+	req, _, err = a.request(ttl, "111", "us-east-1", "i-5678", "m3.large", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.reqs[req.Id].Created = time.Now().Add(-ttl - time.Minute)
+	if reqs := a.list(ttl, "111", "i-5678"); len(reqs) != 1 || reqs[0].State != ApprovalExpired {
+		t.Errorf("expected request to expire, got %v", reqs)
+	}
+	if _, err := a.decide(ttl, "111", req.Id, "bob", true); err == nil {
+		t.Errorf("expired request should not be approvable")
+	}
+
+	a.reqs[req.Id].Created = time.Now().Add(-3 * ttl)
+	if reqs := a.list(ttl, "111", "i-5678"); len(reqs) != 0 {
+		t.Errorf("expected old requests to be removed, got %v", reqs)
+	}
+}
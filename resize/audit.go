@@ -0,0 +1,156 @@
+package resize
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AuditRecord describes a mutating call made to AWS on behalf of a user.
+type AuditRecord struct {
+	Time       time.Time         `json:"time"`
+	User       string            `json:"user"`
+	Session    string            `json:"session"`
+	Region     string            `json:"region"`
+	Action     string            `json:"action"`
+	InstanceId string            `json:"instance_id,omitempty"`
+	Params     map[string]string `json:"params"`
+	RequestId  string            `json:"request_id,omitempty"`
+	Outcome    string            `json:"outcome"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// audit writes a record to the App's AuditLog.
+func (app *App) audit(rec AuditRecord) {
+	if app.AuditLog == nil {
+		return
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		app.Logf("could not marshal audit record: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	app.auditMu.Lock()
+	defer app.auditMu.Unlock()
+	if _, err := app.AuditLog.Write(b); err != nil {
+		app.Logf("could not write audit record: %v", err)
+	}
+}
+
+// mutating reports if an EC2 query API action changes state.
+func mutating(action string) bool {
+	return !strings.HasPrefix(action, "Describe") && !strings.HasPrefix(action, "Get")
+}
+
+// unauditedParams are query parameters added by the request signer which are
+// not recorded.
+var unauditedParams = map[string]bool{
+	"Action":           true,
+	"AWSAccessKeyId":   true,
+	"Signature":        true,
+	"SignatureMethod":  true,
+	"SignatureVersion": true,
+	"Timestamp":        true,
+	"Version":          true,
+}
+
+// auditTransport records every mutating EC2 query API call passing through
+// it to the App's audit log.
+type auditTransport struct {
+	app     *App
+	base    http.RoundTripper
+	user    string
+	session string
+	region  string
+}
+
+// auditClient returns a copy of client which audits calls made with it.
+func (app *App) auditClient(client *http.Client, user, session, region string) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &auditTransport{
+		app:     app,
+		base:    base,
+		user:    user,
+		session: session,
+		region:  region,
+	}
+	return &c
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	action := query.Get("Action")
+	if action == "" || !mutating(action) {
+		return t.base.RoundTrip(req)
+	}
+
+	rec := AuditRecord{
+		Time:    time.Now().UTC(),
+		User:    t.user,
+		Session: t.session,
+		Region:  t.region,
+		Action:  action,
+		Params:  make(map[string]string),
+	}
+	for key := range query {
+		if unauditedParams[key] {
+			continue
+		}
+		rec.Params[key] = query.Get(key)
+		if key == "InstanceId" || (key == "InstanceId.1" && rec.InstanceId == "") {
+			rec.InstanceId = query.Get(key)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Error = err.Error()
+		t.app.audit(rec)
+		return nil, err
+	}
+
+	// read the body to find the request ID, then replace it for the caller
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Error = err.Error()
+		t.app.audit(rec)
+		return resp, nil
+	}
+
+	var result struct {
+		RequestId  string `xml:"requestId"`
+		RequestID  string `xml:"RequestID"`
+		ErrCode    string `xml:"Errors>Error>Code"`
+		ErrMessage string `xml:"Errors>Error>Message"`
+	}
+	xml.Unmarshal(body, &result)
+	rec.RequestId = result.RequestId
+	if rec.RequestId == "" {
+		rec.RequestId = result.RequestID
+	}
+	if resp.StatusCode == http.StatusOK {
+		rec.Outcome = "success"
+	} else {
+		rec.Outcome = "error"
+		rec.Error = resp.Status
+		if result.ErrMessage != "" {
+			rec.Error = result.ErrMessage + " (" + result.ErrCode + ")"
+		}
+	}
+	t.app.audit(rec)
+	return resp, nil
+}
@@ -0,0 +1,92 @@
+package resize
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestAuditTransport(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+
+	buf := new(bytes.Buffer)
+	app := &App{AuditLog: buf}
+	region := aws.Region{Name: "test-region", EC2Endpoint: srv.URL()}
+	auth := aws.Auth{AccessKey: "AKIDTEST", SecretKey: "secret"}
+	client := app.auditClient(http.DefaultClient, "AKIDTEST", "session1", region.Name)
+	ec2Cli := ec2.NewWithClient(auth, region, client)
+
+	resp, err := ec2Cli.RunInstances(&ec2.RunInstances{
+		ImageId:      "ami-1234",
+		InstanceType: "t2.small",
+		MinCount:     1,
+		MaxCount:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Instances) != 1 {
+		t.Fatalf("expected 1 instance, got %d", len(resp.Instances))
+	}
+	id := resp.Instances[0].InstanceId
+
+	// reads are not audited
+	if _, err := ec2Cli.Instances(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ec2Cli.TerminateInstances([]string{id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ec2Cli.TerminateInstances([]string{"i-doesnotexist"}); err == nil {
+		t.Fatal("expected error terminating unknown instance")
+	}
+
+	var records []AuditRecord
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec AuditRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d: %+v", len(records), records)
+	}
+
+	run := records[0]
+	if run.Action != "RunInstances" || run.Outcome != "success" {
+		t.Errorf("unexpected record for RunInstances: %+v", run)
+	}
+	if run.User != "AKIDTEST" || run.Session != "session1" || run.Region != "test-region" {
+		t.Errorf("record does not identify the caller: %+v", run)
+	}
+	if run.Params["InstanceType"] != "t2.small" {
+		t.Errorf("expected InstanceType parameter to be recorded: %+v", run.Params)
+	}
+	if _, ok := run.Params["Signature"]; ok {
+		t.Errorf("signature should not be recorded")
+	}
+	if run.RequestId == "" {
+		t.Errorf("no request ID recorded")
+	}
+
+	term := records[1]
+	if term.Action != "TerminateInstances" || term.InstanceId != id || term.Outcome != "success" {
+		t.Errorf("unexpected record for TerminateInstances: %+v", term)
+	}
+
+	failed := records[2]
+	if failed.Outcome != "error" || failed.Error == "" {
+		t.Errorf("expected failed call to be recorded with its error: %+v", failed)
+	}
+}
@@ -1,8 +1,13 @@
 package resize
 
 import (
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
@@ -14,48 +19,115 @@ func init() {
 
 var defaultRegion = aws.USEast
 
-// login attempts to validate the provided credentials with AWS.
+// login attempts to validate the provided credentials with AWS, or with the
+// named provider if provider is not empty.
 // On an authentication error, error will be of type *ec2.Error
-func (app *App) login(w http.ResponseWriter, r *http.Request, accessKeyID, secretKey string) error {
-	ec2Cli := ec2.NewWithClient(aws.Auth{
+func (app *App) login(w http.ResponseWriter, r *http.Request, provider, accessKeyID, secretKey string) error {
+	region := defaultRegion
+	if provider != "" {
+		p, ok := app.provider(provider)
+		if !ok {
+			return fmt.Errorf("no provider named %s", provider)
+		}
+		region = p.Region()
+	}
+	ec2Cli := app.regionClient(aws.Auth{
 		AccessKey: accessKeyID,
 		SecretKey: secretKey,
-	}, defaultRegion, app.httpClient())
+	}, region, app.httpClient())
 
 	_, err := ec2Cli.Instances(nil, nil)
 	if err != nil {
 		return err
 	}
+	if _, err := app.identify(ec2Cli); err != nil {
+		return err
+	}
+
+	// find the regions this account can use without delaying the login
+	if provider == "" {
+		go app.probeRegions(ec2Cli.Auth)
+	}
 
-	return app.set(w, r, ec2Cli)
+	return app.start(w, r, ec2Cli)
+}
+
+// newId returns a random hex encoded identifier.
+func newId() (string, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// start associates a *ec2.EC2 instance with a new session
+func (app *App) start(w http.ResponseWriter, r *http.Request, ec2Cli *ec2.EC2) error {
+	session := app.session(r)
+	sid, err := newId()
+	if err != nil {
+		return err
+	}
+	created := time.Now()
+	session.Values["ec2"] = ec2Cli
+	session.Values["sid"] = sid
+	session.Values["created"] = created.UnixNano()
+	app.sessions.touch(app.sessionTimeout(), app.idleTimeout(), sid, user(ec2Cli), created, r)
+	return session.Save(r, w)
 }
 
-// set associates a *ec2.EC2 instance with a session
+// set replaces the *ec2.EC2 instance associated with the session
 func (app *App) set(w http.ResponseWriter, r *http.Request, ec2Cli *ec2.EC2) error {
-	// ignore error from decoding an existing session
-	session, _ := app.store.Get(r, "yhat-resize")
+	session := app.session(r)
 	session.Values["ec2"] = ec2Cli
 	return session.Save(r, w)
 }
 
+// logout ends the request's session, so its cookie cannot be used again
+// even if the browser keeps it.
 func (app *App) logout(w http.ResponseWriter, r *http.Request) {
-	session, _ := app.store.Get(r, "yhat-resize")
+	session := app.session(r)
+	if sid, ok := session.Values["sid"].(string); ok {
+		created, _ := session.Values["created"].(int64)
+		app.sessions.end(sid, time.Unix(0, created))
+	}
 	delete(session.Values, "ec2")
+	delete(session.Values, "sid")
+	delete(session.Values, "created")
 	session.Save(r, w)
 }
 
 // creds returns the EC2 credentials associated with the request session. If
-// the session does not
+// the session does not have credentials, or has expired or been revoked, ok
+// is false.
 func (app *App) creds(r *http.Request) (ec2Cli *ec2.EC2, ok bool) {
-	session, _ := app.store.Get(r, "yhat-resize")
+	session := app.session(r)
 	ec2Cli, ok = session.Values["ec2"].(*ec2.EC2)
 	if !ok {
 		return nil, false
 	}
+	sid, _ := session.Values["sid"].(string)
+	created, ok := session.Values["created"].(int64)
+	if !ok || !app.sessions.touch(app.sessionTimeout(), app.idleTimeout(), sid, user(ec2Cli), time.Unix(0, created), r) {
+		return nil, false
+	}
+	if _, err := app.identify(ec2Cli); err != nil {
+		app.Logf("%v", err)
+		return nil, false
+	}
 	// github.com/gorilla/sessions uses encoding/gob to store data which does
 	// not capture hidden fields. To recreate the hidden fields call the
 	// constructor.
-	return ec2.NewWithClient(ec2Cli.Auth, ec2Cli.Region, app.httpClient()), ok
+	client := app.httpClient()
+	if app.AuditLog != nil {
+		client = app.auditClient(client, user(ec2Cli), sid, ec2Cli.Region.Name)
+	}
+	return app.regionClient(ec2Cli.Auth, ec2Cli.Region, client), ok
+}
+
+// user returns the identity of the user holding the credentials.
+func user(ec2Cli *ec2.EC2) string {
+	return ec2Cli.Auth.AccessKey
 }
 
 // restrict a handler to only request which have been logged in
@@ -67,7 +139,7 @@ func (app *App) restrict(h http.Handler) http.Handler {
 		}
 
 		if r.Method == "GET" {
-			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			http.Redirect(w, r, app.url("/login"), http.StatusTemporaryRedirect)
 			return
 		}
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
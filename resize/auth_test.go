@@ -24,12 +24,12 @@ func awsCreds(t *testing.T) (accessKey, secretKey string) {
 }
 
 func TestBadLogin(t *testing.T) {
-	app, err := NewApp("../static", "../templates", nil)
+	app, err := NewApp("../public", "../templates", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	hf := func(w http.ResponseWriter, r *http.Request) {
-		err := app.login(w, r, "foo", "bar")
+		err := app.login(w, r, "", "foo", "bar")
 		switch err := err.(type) {
 		case *ec2.Error:
 		default:
@@ -57,12 +57,12 @@ func TestLogin(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	app, err := NewApp("../static", "../templates", nil)
+	app, err := NewApp("../public", "../templates", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	hf := func(w http.ResponseWriter, r *http.Request) {
-		err := app.login(w, r, accessKey, secretKey)
+		err := app.login(w, r, "", accessKey, secretKey)
 		if err != nil {
 			t.Error(err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -1,12 +1,12 @@
 package resize
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mitchellh/goamz/ec2"
@@ -16,7 +16,9 @@ import (
 	"golang.org/x/net/html/atom"
 )
 
-const instanceTypeURL = "http://aws.amazon.com/ec2/instance-types/"
+// instanceTypeURL is the page listing the instance types, a variable so
+// tests can use a stand-in.
+var instanceTypeURL = "http://aws.amazon.com/ec2/instance-types/"
 
 type InstanceType struct {
 	Name               string  // col 0
@@ -157,90 +159,158 @@ func InstanceTypes(client *http.Client) ([]InstanceType, error) {
 	return types, nil
 }
 
+var (
+	// instanceTypeTTL is how long the scraped instance type catalog is
+	// cached.
+	instanceTypeTTL = time.Hour
+	// catalogRetry is how long to wait after a failed scrape before trying
+	// again.
+	catalogRetry = time.Minute
+	// catalogTimeout limits how long a scrape of the catalog may take.
+	catalogTimeout = 30 * time.Second
+)
+
+// catalog caches the instance type catalog, safe for concurrent use. Only
+// one scrape runs at a time, and never with the lock held.
+type catalog struct {
+	mu      sync.Mutex
+	types   []InstanceType
+	fetched time.Time
+	// fetching is closed when the scrape in progress finishes, and is nil
+	// when there is none.
+	fetching chan struct{}
+	// failed and err record the last failed scrape.
+	failed time.Time
+	err    error
+}
+
+// fetchedAt returns when the catalog was last scraped, or the zero time if
+// it never has been.
+func (c *catalog) fetchedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetched
+}
+
+// instanceTypes returns the cached instance type catalog. A catalog older
+// than instanceTypeTTL is returned while it is scraped again in the
+// background. Callers only wait for a scrape if there is no catalog yet.
+// After a failed scrape, no new one starts for catalogRetry.
+func (app *App) instanceTypes() ([]InstanceType, error) {
+	c := app.catalog
+	c.mu.Lock()
+	if c.types != nil && time.Since(c.fetched) < instanceTypeTTL {
+		defer c.mu.Unlock()
+		return c.types, nil
+	}
+	app.startFetch()
+	if c.types != nil {
+		defer c.mu.Unlock()
+		return c.types, nil
+	}
+	done := c.fetching
+	if done == nil {
+		// the last scrape failed too recently to try again
+		defer c.mu.Unlock()
+		return nil, c.err
+	}
+	c.mu.Unlock()
+	<-done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.types == nil {
+		return nil, c.err
+	}
+	return c.types, nil
+}
+
+// LoadInstanceTypes starts scraping the instance type catalog in the
+// background. It is meant to be called on startup, so the catalog is ready
+// before the first page needs it.
+func (app *App) LoadInstanceTypes() {
+	app.catalog.mu.Lock()
+	defer app.catalog.mu.Unlock()
+	app.startFetch()
+}
+
+// startFetch starts a scrape of the catalog unless one is running or the
+// last failed within catalogRetry. The catalog must be locked.
+func (app *App) startFetch() {
+	c := app.catalog
+	if c.fetching == nil && time.Since(c.failed) >= catalogRetry {
+		c.fetching = make(chan struct{})
+		go app.fetchCatalog(c.fetching)
+	}
+}
+
+// loaded returns an error unless the catalog has been scraped, without
+// scraping it.
+func (c *catalog) loaded() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.types != nil:
+		return nil
+	case c.fetching != nil:
+		return fmt.Errorf("instance type catalog is loading")
+	case c.err != nil:
+		return c.err
+	}
+	return fmt.Errorf("instance type catalog is not loaded")
+}
+
+// fetchCatalog scrapes the instance type catalog and closes done once the
+// result is stored.
+func (app *App) fetchCatalog(done chan struct{}) {
+	client := *app.httpClient()
+	client.Timeout = catalogTimeout
+	types, err := InstanceTypes(&client)
+
+	c := app.catalog
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		app.metrics.scrapeFailures.inc("")
+		c.failed, c.err = time.Now(), err
+		if c.types != nil {
+			app.Logf("could not refresh instance types, using catalog from %s: %v", c.fetched, err)
+		}
+	} else {
+		c.types, c.fetched = types, time.Now()
+		c.failed, c.err = time.Time{}, nil
+	}
+	c.fetching = nil
+	close(done)
+}
+
 func openIps(ec2Cli *ec2.EC2) (open []ec2.Address, err error) {
 	resp, err := ec2Cli.Addresses(nil, nil, nil)
-	for _, addr := range resp.Addresses {
-		if addr.AssociationId == "" {
-			open = append(open, addr)
-		}
+	if notImplemented(err) {
+		return nil, err
 	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting addresses: %v", err)
 	}
+	for _, addr := range resp.Addresses {
+		if addr.AssociationId == "" && addr.InstanceId == "" {
+			open = append(open, addr)
+		}
+	}
 	return open, nil
 }
 
+// stopAndWait stops an instance and polls until it is stopped.
 func stopAndWait(ec2Cli *ec2.EC2, w io.Writer, id string) error {
 	if _, err := ec2Cli.StopInstances(id); err != nil {
 		return fmt.Errorf("error stopping instance: %v", err)
 	}
-	for i := 0; i < 20; i++ {
-		time.Sleep(time.Second * 3)
-		opts := ec2.DescribeInstanceStatus{
-			InstanceIds:         []string{id},
-			IncludeAllInstances: true,
-		}
-		resp, err := ec2Cli.DescribeInstanceStatus(&opts, nil)
-		if err != nil {
-			return fmt.Errorf("error checking instance status: %v", err)
-		}
-		code := -1
-		for _, status := range resp.InstanceStatus {
-			if status.InstanceId == id {
-				e := Event{Status: "message", Message: status.InstanceState.Name}
-				b, err := json.Marshal(e)
-				if err != nil {
-					return fmt.Errorf("error marshalling JSON: %v", err)
-				}
-				w.Write(b)
-				code = status.InstanceState.Code
-			}
-		}
-		if code == -1 {
-			return fmt.Errorf("instance status not available")
-		} else if code == 0 || code == 64 {
-			continue
-		} else if code == 80 {
-			return nil
-		} else {
-			return fmt.Errorf("unexpected instance state: %s", code)
-		}
-	}
-	return fmt.Errorf("timed out waiting for instance to reach 'stopped' state")
+	return waitForState(ec2Cli, w, id, stateStopped)
 }
 
+// pollUntilRunning polls until an instance is running.
 func pollUntilRunning(ec2Cli *ec2.EC2, w io.Writer, id string) error {
-	for i := 0; i < 20; i++ {
-		time.Sleep(time.Second * 2)
-		opts := ec2.DescribeInstanceStatus{
-			InstanceIds:         []string{id},
-			IncludeAllInstances: true,
-		}
-		resp, err := ec2Cli.DescribeInstanceStatus(&opts, nil)
-		if err != nil {
-			return fmt.Errorf("error getting instance status: %v", err)
-		}
-		code := -1
-		for _, status := range resp.InstanceStatus {
-			if status.InstanceId == id {
-				e := Event{Status: "message", Message: status.InstanceState.Name}
-				b, err := json.Marshal(e)
-				if err != nil {
-					return fmt.Errorf("error marshalling JSON: %v", err)
-				}
-				w.Write(b)
-				code = status.InstanceState.Code
-			}
-		}
-		if code == -1 {
-			return fmt.Errorf("Could not get state for this instance")
-		} else if code == 0 {
-			continue
-		} else if code == 16 {
-			return nil
-		}
-	}
-	return fmt.Errorf("Timed out waiting for instance to reach running state")
+	return waitForState(ec2Cli, w, id, stateRunning)
 }
 
 func resize(ec2Cli *ec2.EC2, id string, newType string) error {
@@ -254,19 +324,3 @@ func resize(ec2Cli *ec2.EC2, id string, newType string) error {
 	}
 	return nil
 }
-
-func allocateIp(ec2Cli *ec2.EC2, instanceId string, allocId string) error {
-	opts := &ec2.AssociateAddress{
-		InstanceId:         instanceId,
-		AllocationId:       allocId,
-		AllowReassociation: false,
-	}
-	resp, err := ec2Cli.AssociateAddress(opts)
-	if err != nil {
-		return fmt.Errorf("could not associate address: %v", err)
-	}
-	if !resp.Return {
-		return fmt.Errorf("bad response from AWS")
-	}
-	return nil
-}
@@ -2,7 +2,10 @@ package resize
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,6 +20,55 @@ func TestInstanceTypes(t *testing.T) {
 	}
 }
 
+func TestCatalogScrape(t *testing.T) {
+	var mu sync.Mutex
+	scrapes := 0
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		scrapes++
+		mu.Unlock()
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	defer func(url string) { instanceTypeURL = url }(instanceTypeURL)
+	instanceTypeURL = s.URL
+
+	app := &App{HTTPClient: &http.Client{}, metrics: newMetrics(), catalog: &catalog{}}
+	errs := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := app.instanceTypes()
+			errs <- err
+		}()
+	}
+
+	// the catalog is not locked during the scrape
+	done := make(chan bool)
+	go func() { done <- app.catalog.fetchedAt().IsZero() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("catalog locked while scraping")
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err == nil {
+			t.Error("expected the failed scrape to be reported")
+		}
+	}
+	if _, err := app.instanceTypes(); err == nil {
+		t.Error("expected the failure to be remembered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if scrapes != 1 {
+		t.Errorf("expected a single scrape, got %d", scrapes)
+	}
+}
+
 // taken from http://cloud-images.ubuntu.com/locator/ec2/
 var UbuntuInstances = map[string]string{
 	"ap-northeast-1": "ami-d4c807d4",
@@ -0,0 +1,178 @@
+package resize
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// MetricSource provides the statistics for a metric, as returned by the
+// CloudWatch GetMetricStatistics call.
+type MetricSource interface {
+	GetMetricStatistics(req *GetMetricStatistics) ([]Datapoint, error)
+}
+
+// GetMetricStatistics describes a CloudWatch GetMetricStatistics call.
+type GetMetricStatistics struct {
+	Namespace  string
+	MetricName string
+	Dimensions map[string]string
+	StartTime  time.Time
+	EndTime    time.Time
+	Period     time.Duration
+	Statistics []string
+	Unit       string
+}
+
+// Datapoint is a single statistic of a metric.
+type Datapoint struct {
+	Timestamp time.Time `xml:"Timestamp"`
+	Average   float64   `xml:"Average"`
+	Maximum   float64   `xml:"Maximum"`
+	Minimum   float64   `xml:"Minimum"`
+	Sum       float64   `xml:"Sum"`
+	Unit      string    `xml:"Unit"`
+}
+
+// CloudWatch is a minimal client for the CloudWatch query API.
+type CloudWatch struct {
+	Auth     aws.Auth
+	Endpoint string
+	Client   *http.Client
+}
+
+// cloudWatchEndpoint returns the CloudWatch endpoint for a region.
+func cloudWatchEndpoint(region aws.Region) string {
+	if strings.HasPrefix(region.Name, "cn-") {
+		return "https://monitoring." + region.Name + ".amazonaws.com.cn"
+	}
+	return "https://monitoring." + region.Name + ".amazonaws.com"
+}
+
+// NewCloudWatch returns a client for the region's CloudWatch endpoint.
+func NewCloudWatch(auth aws.Auth, region aws.Region, client *http.Client) *CloudWatch {
+	return &CloudWatch{Auth: auth, Endpoint: cloudWatchEndpoint(region), Client: client}
+}
+
+type cloudWatchError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func (cw *CloudWatch) GetMetricStatistics(req *GetMetricStatistics) ([]Datapoint, error) {
+	params := map[string]string{
+		"Action":     "GetMetricStatistics",
+		"Version":    "2010-08-01",
+		"Timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"Namespace":  req.Namespace,
+		"MetricName": req.MetricName,
+		"StartTime":  req.StartTime.UTC().Format(time.RFC3339),
+		"EndTime":    req.EndTime.UTC().Format(time.RFC3339),
+		"Period":     strconv.Itoa(int(req.Period.Seconds())),
+	}
+	if req.Unit != "" {
+		params["Unit"] = req.Unit
+	}
+	for i, stat := range req.Statistics {
+		params["Statistics.member."+strconv.Itoa(i+1)] = stat
+	}
+	names := make([]string, 0, len(req.Dimensions))
+	for name := range req.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		prefix := "Dimensions.member." + strconv.Itoa(i+1)
+		params[prefix+".Name"] = name
+		params[prefix+".Value"] = req.Dimensions[name]
+	}
+
+	endpoint, err := url.Parse(cw.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Path == "" {
+		endpoint.Path = "/"
+	}
+	signV2(cw.Auth, "", "GET", endpoint.Path, params, endpoint.Host)
+	q := make(url.Values)
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	endpoint.RawQuery = q.Encode()
+
+	client := cw.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(endpoint.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var cwErr cloudWatchError
+		xml.NewDecoder(resp.Body).Decode(&cwErr)
+		if cwErr.Message == "" {
+			return nil, fmt.Errorf("bad response from CloudWatch: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("bad response from CloudWatch: %s (%s)", cwErr.Message, cwErr.Code)
+	}
+	var result struct {
+		Datapoints []Datapoint `xml:"GetMetricStatisticsResult>Datapoints>member"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	sort.Sort(byTimestamp(result.Datapoints))
+	return result.Datapoints, nil
+}
+
+type byTimestamp []Datapoint
+
+func (s byTimestamp) Len() int           { return len(s) }
+func (s byTimestamp) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTimestamp) Less(i, j int) bool { return s[i].Timestamp.Before(s[j].Timestamp) }
+
+// signV2 signs query API parameters using AWS signature version 2, the same
+// scheme used by the ec2 package. The signature method is "HmacSHA256" or
+// "HmacSHA1"; if empty, HmacSHA256 is used.
+func signV2(auth aws.Auth, sigMethod, method, path string, params map[string]string, host string) {
+	if sigMethod == "" {
+		sigMethod = "HmacSHA256"
+	}
+	params["AWSAccessKeyId"] = auth.AccessKey
+	params["SignatureVersion"] = "2"
+	params["SignatureMethod"] = sigMethod
+	if auth.Token != "" {
+		params["SecurityToken"] = auth.Token
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = aws.Encode(k) + "=" + aws.Encode(params[k])
+	}
+	payload := method + "\n" + host + "\n" + path + "\n" + strings.Join(pairs, "&")
+	h := sha256.New
+	if sigMethod == "HmacSHA1" {
+		h = sha1.New
+	}
+	hash := hmac.New(h, []byte(auth.SecretKey))
+	hash.Write([]byte(payload))
+	params["Signature"] = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
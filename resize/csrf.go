@@ -0,0 +1,119 @@
+package resize
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken returns the CSRF token bound to the request's session, creating
+// and saving a new one if the session does not yet have a token.
+func (app *App) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session := app.session(r)
+	if token, ok := session.Values["csrf"].(string); ok && token != "" {
+		return token, nil
+	}
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	token := base64.StdEncoding.EncodeToString(b)
+	session.Values["csrf"] = token
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// validCSRF reports if the request carries the CSRF token of its session,
+// either as a form value or in the X-CSRF-Token header.
+func (app *App) validCSRF(r *http.Request) bool {
+	session := app.session(r)
+	expected, ok := session.Values["csrf"].(string)
+	if !ok || expected == "" {
+		return false
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.FormValue(csrfField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// maxUploadSize limits the body of state changing requests, the largest of
+// which are utilization CSV uploads.
+const maxUploadSize = 10 << 20
+
+// csrf rejects any state changing request which does not include the CSRF
+// token of its session.
+func (app *App) csrf(h http.Handler) http.Handler {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			// the token may be in a multipart form, limit the body before
+			// it is parsed to look for it
+			r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+			if !app.validCSRF(r) {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(hf)
+}
+
+// wsHandler wraps a websocket handler with a handshake that only accepts
+// connections from allowed origins.
+func (app *App) wsHandler(h websocket.Handler) http.Handler {
+	counted := func(ws *websocket.Conn) {
+		app.metrics.websockets.add(1)
+		defer app.metrics.websockets.add(-1)
+		h(ws)
+	}
+	return websocket.Server{Handler: counted, Handshake: app.checkOrigin}
+}
+
+// checkOrigin validates the Origin header of a websocket handshake. If
+// AllowedOrigins is empty, only the origin the request was made to is
+// accepted.
+func (app *App) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil {
+		return fmt.Errorf("websocket: no origin provided")
+	}
+	if !app.allowedOrigin(origin, r) {
+		return fmt.Errorf("websocket: origin %s not allowed", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+func (app *App) allowedOrigin(origin *url.URL, r *http.Request) bool {
+	if len(app.AllowedOrigins) == 0 {
+		return strings.EqualFold(origin.Host, r.Host)
+	}
+	o := strings.ToLower(origin.Scheme + "://" + origin.Host)
+	for _, allowed := range app.AllowedOrigins {
+		if strings.TrimSuffix(strings.ToLower(allowed), "/") == o {
+			return true
+		}
+	}
+	return false
+}
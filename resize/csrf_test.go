@@ -0,0 +1,119 @@
+package resize
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestCSRF(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			token, err := app.csrfToken(w, r)
+			if err != nil {
+				t.Error(err)
+			}
+			w.Write([]byte(token))
+		}
+	}
+	s := httptest.NewServer(app.csrf(http.HandlerFunc(hf)))
+	defer s.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &http.Client{Jar: jar}
+
+	resp, err := cli.Get(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := string(b)
+	if token == "" {
+		t.Fatal("no csrf token returned")
+	}
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusForbidden},
+		{"not-the-token", http.StatusForbidden},
+		{token, http.StatusOK},
+	}
+	for _, test := range tests {
+		resp, err := cli.PostForm(s.URL+"/", url.Values{csrfField: {test.token}})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("token %q: expected status %d got %s", test.token, test.status, resp.Status)
+		}
+	}
+
+	// a client without the session cookie must not be able to reuse the token
+	req, err := http.NewRequest("POST", s.URL+"/", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(csrfHeader, token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected token without session to be rejected, got %s", resp.Status)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		host    string
+		origin  string
+		ok      bool
+	}{
+		{nil, "localhost:4040", "http://localhost:4040", true},
+		{nil, "localhost:4040", "http://evil.example.com", false},
+		{nil, "localhost:4040", "", false},
+		{[]string{"https://resize.example.com"}, "localhost:4040", "https://resize.example.com", true},
+		{[]string{"https://resize.example.com/"}, "localhost:4040", "https://RESIZE.example.com", true},
+		{[]string{"https://resize.example.com"}, "localhost:4040", "http://resize.example.com", false},
+		{[]string{"https://resize.example.com"}, "localhost:4040", "http://localhost:4040", false},
+	}
+	for _, test := range tests {
+		app := &App{AllowedOrigins: test.allowed}
+		r, err := http.NewRequest("GET", "http://"+test.host+"/instance/i-1234/resize", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		config := &websocket.Config{Version: websocket.ProtocolVersionHybi13}
+		err = app.checkOrigin(config, r)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("allowed=%v host=%s origin=%q: expected ok=%t, got error %v",
+				test.allowed, test.host, test.origin, test.ok, err)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package resize
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
 	"golang.org/x/net/websocket"
 )
@@ -14,6 +18,10 @@ func allInstances(resp *ec2.InstancesResp) []ec2.Instance {
 	instances := []ec2.Instance{}
 	for _, res := range resp.Reservations {
 		for _, inst := range res.Instances {
+			// EC2-Classic responses may only list groups on the reservation
+			if len(inst.SecurityGroups) == 0 {
+				inst.SecurityGroups = res.SecurityGroups
+			}
 			instances = append(instances, inst)
 		}
 	}
@@ -31,12 +39,30 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not implemented", http.StatusNotImplemented)
 		return
 	}
-	resp, err := ec2Cli.Instances(nil, nil)
+	query, err := ParseInventoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := ec2Cli.Instances(nil, query.Filter())
 	if err != nil {
 		app.render500(w, r, err)
 		return
 	}
-	data := map[string]interface{}{"Instances": allInstances(resp)}
+	page := query.page(allInstances(resp))
+	missing := make(map[string][]TagRule)
+	for _, instance := range page.Instances {
+		if m := app.missingTags(instance.Tags); len(m) > 0 {
+			missing[instance.InstanceId] = m
+		}
+	}
+	data := map[string]interface{}{
+		"Instances":   page.Instances,
+		"Page":        page,
+		"Query":       query,
+		"States":      instanceStates,
+		"MissingTags": missing,
+	}
 	app.render(w, r, "index.html", data)
 }
 
@@ -44,11 +70,11 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		if _, ok := app.creds(r); ok {
-			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			http.Redirect(w, r, app.url("/"), http.StatusTemporaryRedirect)
 			return
 		}
 
-		app.render(w, r, "login.html", nil)
+		app.render(w, r, "login.html", map[string]interface{}{"Providers": app.Providers})
 		return
 	}
 	if r.Method != "POST" {
@@ -67,14 +93,14 @@ func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No secret key provided", http.StatusBadRequest)
 		return
 	}
-	err := app.login(w, r, accessKey, secretKey)
+	err := app.login(w, r, r.FormValue("provider"), accessKey, secretKey)
 	if err == nil {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
 	if err, ok := err.(*ec2.Error); ok {
-		msg := fmt.Sprintf("bad response from AWS '%s'", err.Message)
+		msg := fmt.Sprintf("bad response from EC2 '%s'", err.Message)
 		http.Error(w, msg, http.StatusBadRequest)
 	} else {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -89,7 +115,7 @@ func (app *App) handleAbout(w http.ResponseWriter, r *http.Request) {
 // Path: /logout
 func (app *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 	app.logout(w, r)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, app.url("/"), http.StatusSeeOther)
 }
 
 // Path: /region
@@ -108,19 +134,21 @@ func (app *App) handleRegion(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No region provided", http.StatusBadRequest)
 		return
 	}
-	region, ok := aws.Regions[regionName]
-	if !ok {
+	if _, ok := app.region(ec2Cli, regionName); !ok {
 		http.Error(w, "No AWS region named "+regionName, http.StatusBadRequest)
 		return
 	}
-
-	ec2Cli.Region = region
-	if err := app.set(w, r, ec2Cli); err != nil {
+	if err := app.selectRegion(w, r, ec2Cli, regionName); err != nil {
 		app.Logf("could not set region for cookie: %v", err)
 		http.Error(w, "internal error setting cookie", http.StatusInternalServerError)
 		return
 	}
 
+	// switching to show an instance from another region goes on to it
+	if instanceId := r.PostFormValue("instance"); instanceId != "" {
+		http.Redirect(w, r, app.url("/instance/"+url.PathEscape(instanceId)), http.StatusSeeOther)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -142,6 +170,26 @@ func (app *App) handleInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// links from other regions ask to switch to the instance's region,
+	// which changes the session so is only done by a POST to /region
+	if region := r.URL.Query().Get("region"); region != "" {
+		if region == ec2Cli.Region.Name {
+			http.Redirect(w, r, app.url("/instance/"+instanceId), http.StatusSeeOther)
+			return
+		}
+		if _, ok := app.region(ec2Cli, region); !ok {
+			http.Error(w, "No AWS region named "+region, http.StatusBadRequest)
+			return
+		}
+		data := map[string]interface{}{
+			"InstanceId": instanceId,
+			"Region":     region,
+			"Current":    ec2Cli.Region.Name,
+		}
+		app.render(w, r, "switchregion.html", data)
+		return
+	}
+
 	resp, err := ec2Cli.Instances([]string{instanceId}, nil)
 	if err != nil {
 		app.render500(w, r, fmt.Errorf("Bad response from AWS %v", err))
@@ -155,32 +203,216 @@ func (app *App) handleInstance(w http.ResponseWriter, r *http.Request) {
 	instance := instances[0]
 	data := map[string]interface{}{"Instance": instance}
 
-	addresses, err := openIps(ec2Cli)
+	if app.features.supported(ec2Cli.Region.Name, featureAddresses) {
+		addresses, err := openIps(ec2Cli)
+		switch {
+		case notImplemented(err):
+			// hide Elastic IPs on EC2-compatible clouds without them
+			app.features.disable(ec2Cli.Region.Name, featureAddresses)
+		case err != nil:
+			app.render500(w, r, err)
+			return
+		default:
+			data["Addresses"] = addresses
+
+			filter := ec2.NewFilter()
+			filter.Add("instance-id", instanceId)
+			addrResp, err := ec2Cli.Addresses(nil, nil, filter)
+			if err == nil && (len(addrResp.Addresses) == 1) {
+				data["Address"] = addrResp.Addresses[0]
+			}
+		}
+	}
+	types, err := app.instanceTypes()
 	if err != nil {
 		app.render500(w, r, err)
 		return
 	}
-	data["Addresses"] = addresses
+	data["InstanceTypes"] = types
+	if cost, prices, ok := app.costs(ec2Cli, instance, types); ok {
+		data["Cost"] = cost
+		data["Prices"] = prices
+	}
+	if app.Recommend {
+		data["Recommendation"] = app.recommend(ec2Cli, instance, types)
+	}
+	if volumes, err := instanceVolumes(ec2Cli, instanceId); err == nil {
+		data["Volumes"] = volumes
+		data["VolumeTypes"] = volumeTypes
+	} else {
+		app.Logf("could not list volumes of %s: %v", instanceId, err)
+	}
+	if groups, err := instanceGroups(ec2Cli, instance); err == nil {
+		var rules []groupRules
+		for _, g := range groups {
+			rules = append(rules, groupRules{Group: g, Rules: flattenRules(g)})
+		}
+		data["SecurityGroups"] = rules
+	} else {
+		app.Logf("could not describe security groups of %s: %v", instanceId, err)
+	}
+	data["MissingTags"] = app.missingTags(instance.Tags)
+	data["Protected"] = app.protected(instance)
+	data["RequiresApproval"] = app.requiresApproval(instance)
+	data["Approvals"] = app.approvals.list(app.approvalTTL(), app.identity(ec2Cli).Account, instanceId)
 
+	app.render(w, r, "instance.html", data)
+}
+
+// Path: /instance/{instance}/utilization
+func (app *App) handleUtilization(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, _, err := r.FormFile("utilization")
+	if err != nil {
+		http.Error(w, "No utilization CSV provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	u, err := ParseUtilizationCSV(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	app.uploads.set(app.identity(ec2Cli).Account, ec2Cli.Region.Name, instanceId, u)
+	http.Redirect(w, r, app.url("/instance/"+instanceId), http.StatusSeeOther)
+}
+
+// Path: /recommendations
+func (app *App) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
 	filter := ec2.NewFilter()
-	filter.Add("instance-id", instanceId)
-	addrResp, err := ec2Cli.Addresses(nil, nil, filter)
-	if err == nil && (len(addrResp.Addresses) == 1) {
-		data["Address"] = addrResp.Addresses[0]
+	filter.Add("instance-state-name", "running")
+	resp, err := ec2Cli.Instances(nil, filter)
+	if err != nil {
+		app.render500(w, r, err)
+		return
 	}
-	types, err := InstanceTypes(nil)
+	types, err := app.instanceTypes()
 	if err != nil {
 		app.render500(w, r, err)
 		return
 	}
-	data["InstanceTypes"] = types
+	instances := allInstances(resp)
+	recs := make([]Recommendation, len(instances))
+
+	// query CloudWatch for several instances at once
+	const workers = 8
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				recs[i] = app.recommend(ec2Cli, instances[i], types)
+			}
+		}()
+	}
+	for i := range instances {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	data := map[string]interface{}{"Recommendations": recs}
+	app.render(w, r, "recommendations.html", data)
+}
 
-	app.render(w, r, "instance.html", data)
+// Path: /approvals
+func (app *App) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	data := map[string]interface{}{
+		"Approvals": app.approvals.list(app.approvalTTL(), app.identity(ec2Cli).Account, ""),
+		"User":      app.identity(ec2Cli).Principal,
+	}
+	app.render(w, r, "approvals.html", data)
+}
+
+// Path: /approvals/{approval}
+func (app *App) handleDecide(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	var approve bool
+	switch r.PostFormValue("decision") {
+	case "approve":
+		approve = true
+	case "reject":
+		approve = false
+	default:
+		http.Error(w, "Decision must be 'approve' or 'reject'", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["approval"]
+	identity := app.identity(ec2Cli)
+	req, err := app.approvals.decide(app.approvalTTL(), identity.Account, id, identity.Principal, approve)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	app.record(ec2Cli, HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     req.Region,
+		InstanceId: req.InstanceId,
+		Action:     "approval",
+		Message:    fmt.Sprintf("%s request %s by %s to resize to %s", req.State, req.Id, req.Requester, req.NewType),
+	})
+	http.Redirect(w, r, app.url("/approvals"), http.StatusSeeOther)
+}
+
+// Path: /history
+func (app *App) handleHistory(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	data := map[string]interface{}{"History": app.history.list(app.identity(ec2Cli).Account)}
+	app.render(w, r, "history.html", data)
 }
 
 type Event struct {
 	Status  string
 	Message string
+
+	// JobId is set on errors if the failed job can be recovered.
+	JobId string `json:",omitempty"`
 }
 
 func (app *App) handleResize(ws *websocket.Conn) {
@@ -201,45 +433,223 @@ func (app *App) handleResize(ws *websocket.Conn) {
 
 	currentStatus := r.URL.Query().Get("status")
 
-	var newType string
-	if err := websocket.Message.Receive(ws, &newType); err != nil {
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
 		app.wsErr(ws, fmt.Sprintf("error receiving websocket message: %v", err))
 		return
 	}
+	req, err := parseResizeRequest(msg)
+	if err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	newType := req.Type
+
+	if err := app.checkProtected(ec2Cli, instanceId); err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	approval, ok, err := app.approved(ws, ec2Cli, instanceId, newType)
+	if err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	} else if !ok {
+		return
+	}
+
+	job, err := app.jobs.start(app.identity(ec2Cli).Account, ec2Cli.Region.Name, instanceId, user(ec2Cli), newType)
+	if err != nil {
+		app.wsErr(ws, fmt.Sprintf("could not start job: %v", err))
+		return
+	}
+	entry := HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     ec2Cli.Region.Name,
+		InstanceId: instanceId,
+		JobId:      job.Id,
+		Action:     "resize",
+	}
+	// fail reports an error to the user and records the failure, reason is
+	// used to label the failure metric
+	fail := func(reason, msg string) {
+		app.Logf("%s", msg)
+		app.metrics.resizesFailed.inc(reason)
+		app.jobs.finish(job.Id, fmt.Errorf("%s", msg))
+		e := Event{Status: "error", Message: msg}
+		if job, ok := app.jobs.get(job.Account, job.Id); ok && job.Recoverable() {
+			e.JobId = job.Id
+		}
+		websocket.JSON.Send(ws, &e)
+		entry.Message = fmt.Sprintf("failed to resize to %s: %s", newType, msg)
+		app.record(ec2Cli, entry)
+	}
+
+	// the approval is only used up once the job has started, and only by
+	// one job
+	if approval != "" && !app.approvals.consume(app.approvalTTL(), job.Account, approval) {
+		fail("approval", fmt.Sprintf("approval %s has already been used", approval))
+		return
+	}
+
+	app.metrics.resizesStarted.inc("")
 
 	//The instance must be stopped before we can change it
 	switch currentStatus {
 	case "running":
+		start := time.Now()
 		if err := stopAndWait(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error stopping instance: %v", err))
+			fail("stop", fmt.Sprintf("error stopping instance: %v", err))
 			return
 		}
+		app.metrics.phaseDuration.since("stop", start)
 	case "stopped":
 		break
 	default:
-		app.wsErr(ws, "The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
+		fail("state", "The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
 		return
 	}
+	var instance ec2.Instance
+	if req.Snapshot != SnapshotNone || req.Image {
+		resp, err := ec2Cli.Instances([]string{instanceId}, nil)
+		if err != nil {
+			fail("describe", fmt.Sprintf("Bad response from AWS %v", err))
+			return
+		}
+		instances := allInstances(resp)
+		if len(instances) != 1 {
+			fail("describe", fmt.Sprintf("instance %s not found", instanceId))
+			return
+		}
+		instance = instances[0]
+	}
+	if req.Image {
+		start := time.Now()
+		imageId, err := createImage(ec2Cli, ws, instance, job.Id)
+		app.jobs.update(job.Id, func(job *Job) {
+			job.ImageId = imageId
+			if err == nil {
+				job.Original = &instance
+			}
+		})
+		if err != nil {
+			fail("image", fmt.Sprintf("error creating image: %v", err))
+			return
+		}
+		app.metrics.phaseDuration.since("image", start)
+	}
+	if req.Snapshot != SnapshotNone {
+		start := time.Now()
+		ids, err := snapshotVolumes(ec2Cli, ws, instance, job.Id, req.Snapshot)
+		app.jobs.update(job.Id, func(job *Job) { job.Snapshots = ids })
+		if err != nil {
+			fail("snapshot", fmt.Sprintf("error snapshotting volumes: %v", err))
+			return
+		}
+		app.metrics.phaseDuration.since("snapshot", start)
+	}
+	start := time.Now()
 	if err := resize(ec2Cli, instanceId, newType); err != nil {
-		app.wsErr(ws, fmt.Sprintf("error resizing instance: %v", err))
+		fail("modify", fmt.Sprintf("error resizing instance: %v", err))
 		return
 	}
+	app.metrics.phaseDuration.since("modify", start)
 	//If the server was running initially, we'll return it to its original
 	//state and keep the user informed of this process
 	if currentStatus == "running" {
+		start := time.Now()
 		if _, err := ec2Cli.StartInstances(instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error starting instance: %v", err))
+			fail("start", fmt.Sprintf("error starting instance: %v", err))
 			return
 		}
 		if err := pollUntilRunning(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error checking instance status: %v", err))
+			fail("start", fmt.Sprintf("error checking instance status: %v", err))
 			return
 		}
+		app.metrics.phaseDuration.since("start", start)
 	}
+	app.metrics.resizesSucceeded.inc("")
+	app.jobs.finish(job.Id, nil)
+	entry.Message = "resized to " + newType
+	app.record(ec2Cli, entry)
 	e := Event{Status: "success"}
 	websocket.JSON.Send(ws, &e)
 }
 
+// resizeRequest is the message sent by the client to begin a resize. Older
+// clients send only the new instance type as plain text.
+type resizeRequest struct {
+	Type     string
+	Snapshot string
+
+	// Image requests an AMI of the stopped instance before it is modified.
+	Image bool
+}
+
+func parseResizeRequest(msg string) (resizeRequest, error) {
+	var req resizeRequest
+	if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		req.Type = strings.TrimSpace(msg)
+	} else if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return req, fmt.Errorf("malformed resize request: %v", err)
+	}
+	if req.Type == "" {
+		return req, fmt.Errorf("no instance type provided")
+	}
+	switch req.Snapshot {
+	case SnapshotNone, SnapshotPending, SnapshotCompleted:
+	default:
+		return req, fmt.Errorf("unknown snapshot option %q", req.Snapshot)
+	}
+	return req, nil
+}
+
+// approved reports if the resize may run, and the ID of the approved request
+// to consume once it has started, if the instance requires approval. If no
+// approved request exists, a request is opened, approvers are notified and
+// a "pending" event is sent to the client.
+func (app *App) approved(ws *websocket.Conn, ec2Cli *ec2.EC2, instanceId, newType string) (string, bool, error) {
+	if app.ApprovalRule == nil {
+		return "", true, nil
+	}
+	resp, err := ec2Cli.Instances([]string{instanceId}, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("Bad response from AWS %v", err)
+	}
+	instances := allInstances(resp)
+	if len(instances) != 1 {
+		return "", false, fmt.Errorf("instance %s not found", instanceId)
+	}
+	if !app.requiresApproval(instances[0]) {
+		return "", true, nil
+	}
+
+	ttl := app.approvalTTL()
+	region := ec2Cli.Region.Name
+	identity := app.identity(ec2Cli)
+	if req, ok := app.approvals.find(ttl, identity.Account, region, instanceId, newType, identity.Principal); ok {
+		return req.Id, true, nil
+	}
+	req, created, err := app.approvals.request(ttl, identity.Account, region, instanceId, newType, identity.Principal)
+	if err != nil {
+		return "", false, fmt.Errorf("could not create approval request: %v", err)
+	}
+	if created {
+		app.notify(req)
+		app.record(ec2Cli, HistoryEntry{
+			User:       req.Requester,
+			Region:     req.Region,
+			InstanceId: req.InstanceId,
+			Action:     "approval",
+			Message:    fmt.Sprintf("requested approval %s to resize to %s", req.Id, req.NewType),
+		})
+	}
+	msg := fmt.Sprintf("Resizing this instance must be approved by another user. "+
+		"Request %s is pending, start the resize again once it has been approved.", req.Id)
+	e := Event{Status: "pending", Message: msg}
+	websocket.JSON.Send(ws, &e)
+	return "", false, nil
+}
+
 func (app *App) handleAssignIp(ws *websocket.Conn) {
 	defer ws.Close()
 
@@ -255,41 +665,23 @@ func (app *App) handleAssignIp(ws *websocket.Conn) {
 		app.wsErr(ws, "No instance ID included")
 		return
 	}
-	currentStatus := r.URL.Query().Get("status")
 
-	var allocId string
-	if err := websocket.Message.Receive(ws, &allocId); err != nil {
+	var id string
+	if err := websocket.Message.Receive(ws, &id); err != nil {
 		app.wsErr(ws, fmt.Sprintf("error receiving websocket message: %v", err))
 		return
 	}
 
-	switch currentStatus {
-	case "running":
-		if err := stopAndWait(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error stopping instance: %v", err))
-			return
-		}
-	case "stopped":
-		break
-	default:
-		app.wsErr(ws, "The server is not in a state from which its size can be changed. The server's state must be either 'stopped' or 'running.'")
-		return
-	}
-
-	err := allocateIp(ec2Cli, instanceId, allocId)
+	// addresses can be associated with running instances, so there's no
+	// need to stop it first
+	addr, err := findAddress(ec2Cli, id)
 	if err != nil {
-		app.wsErr(ws, fmt.Sprintf("could not allocate elastic IP: %v", err))
+		app.wsErr(ws, err.Error())
 		return
 	}
-	if currentStatus == "running" {
-		if _, err := ec2Cli.StartInstances(instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error starting instance: %v", err))
-			return
-		}
-		if err := pollUntilRunning(ec2Cli, ws, instanceId); err != nil {
-			app.wsErr(ws, fmt.Sprintf("error checking instance status: %v", err))
-			return
-		}
+	if err := app.changeAddress(ec2Cli, addr, instanceId, "associate"); err != nil {
+		app.wsErr(ws, fmt.Sprintf("could not associate elastic IP: %v", err))
+		return
 	}
 	e := Event{Status: "success"}
 	websocket.JSON.Send(ws, &e)
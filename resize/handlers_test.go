@@ -0,0 +1,145 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestParseResizeRequest(t *testing.T) {
+	tests := []struct {
+		msg      string
+		typ      string
+		snapshot string
+		image    bool
+		ok       bool
+	}{
+		{"m3.large", "m3.large", SnapshotNone, false, true},
+		{`{"Type":"m3.large"}`, "m3.large", SnapshotNone, false, true},
+		{`{"Type":"m3.large","Snapshot":"completed"}`, "m3.large", SnapshotCompleted, false, true},
+		{`{"Type":"m3.large","Image":true}`, "m3.large", SnapshotNone, true, true},
+		{`{"Type":"m3.large","Snapshot":"sometimes"}`, "", "", false, false},
+		{`{"Snapshot":"pending"}`, "", "", false, false},
+		{`{"Type":`, "", "", false, false},
+		{"", "", "", false, false},
+	}
+	for _, test := range tests {
+		req, err := parseResizeRequest(test.msg)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%q: expected ok=%t, got error %v", test.msg, test.ok, err)
+			continue
+		}
+		if test.ok && (req.Type != test.typ || req.Snapshot != test.snapshot || req.Image != test.image) {
+			t.Errorf("%q: unexpected request %+v", test.msg, req)
+		}
+	}
+}
+
+func TestInstancePageWithoutPricing(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+	ids := srv.NewInstances(1, "m3.medium", "ami-1", ec2test.Running, nil)
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.catalog.types = []InstanceType{{Name: "m3.medium"}, {Name: "m3.large"}}
+	app.catalog.fetched = time.Now()
+
+	region := aws.Region{Name: "test", EC2Endpoint: srv.URL()}
+	// the test server has no Elastic IPs
+	app.features.disable(region.Name, featureAddresses)
+	s := loginSession(t, app, "AKIDALICE", region)
+
+	w := s.do("GET", "/instance/"+ids[0], nil)
+	// template errors are only logged, leaving the page cut short
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "</html>") {
+		t.Fatalf("expected the whole instance page, got %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `<option value="m3.large"`) {
+		t.Error("expected the other instance types to be listed")
+	}
+	if strings.Contains(w.Body.String(), "data-hourly") {
+		t.Error("expected no prices without pricing configured")
+	}
+}
+
+// testSession is a logged in session of a user of account 111, for
+// requests through the App's router.
+type testSession struct {
+	app    *App
+	cookie string
+	token  string
+}
+
+func loginSession(t *testing.T, app *App, accessKey string, region aws.Region) *testSession {
+	ec2Cli := ec2.New(aws.Auth{AccessKey: accessKey, SecretKey: "secret"}, region)
+	app.identities.set(app.identityKey(ec2Cli), Identity{Account: "111", Principal: "arn:aws:iam::111:user/" + accessKey})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/login", nil)
+	if err := app.start(w, r, ec2Cli); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	w = httptest.NewRecorder()
+	token, err := app.csrfToken(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSession{app: app, cookie: w.Header().Get("Set-Cookie"), token: token}
+}
+
+// do sends a request, with the CSRF token if it has a form.
+func (s *testSession) do(method, path string, form url.Values) *httptest.ResponseRecorder {
+	if form != nil {
+		form.Set(csrfField, s.token)
+	}
+	r, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Cookie", s.cookie)
+	w := httptest.NewRecorder()
+	s.app.ServeHTTP(w, r)
+	return w
+}
+
+func TestInstanceInOtherRegion(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loginSession(t, app, "AKIDALICE", aws.USEast)
+
+	w := s.do("GET", "/instance/i-1234?region=us-west-2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a page offering to switch region, got %d", w.Code)
+	}
+	if c := w.Header().Get("Set-Cookie"); c != "" {
+		t.Errorf("a GET must not change the session, got cookie %s", c)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `action="region"`) || !strings.Contains(body, "</html>") {
+		t.Errorf("expected a form switching region, got %s", body)
+	}
+	if w := s.do("GET", "/instance/i-1234?region=nowhere", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown region to be refused, got %d", w.Code)
+	}
+
+	w = s.do("POST", "/region", url.Values{"region": {"us-west-2"}, "instance": {"i-1234"}})
+	if w.Code != http.StatusSeeOther || !strings.HasSuffix(w.Header().Get("Location"), "/instance/i-1234") {
+		t.Errorf("expected switching region to go on to the instance, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w.Header().Get("Set-Cookie") == "" {
+		t.Errorf("expected the session to switch region")
+	}
+}
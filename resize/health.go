@@ -0,0 +1,148 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// readyTimeout bounds the request made to ReadyEndpoint.
+var readyTimeout = 5 * time.Second
+
+// Check is the result of one readiness check.
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Health is the JSON body of /healthz and /readyz.
+type Health struct {
+	Status string  `json:"status"`
+	Checks []Check `json:"checks,omitempty"`
+}
+
+type readyCheck struct {
+	name string
+	run  func() error
+}
+
+// isProbe reports if a request path is a health check. Health checks are
+// served without a login, at the root as well as under the base path.
+func isProbe(p string) bool {
+	return p == "/healthz" || p == "/readyz"
+}
+
+// IsProbe reports if r is a health check, at the root or under the base
+// path. Load balancers poll these often, so they are best left out of
+// access logs.
+func (app *App) IsProbe(r *http.Request) bool {
+	p := r.URL.Path
+	if app.BasePath != "" && strings.HasPrefix(p, app.BasePath) {
+		p = strings.TrimPrefix(p, app.BasePath)
+	}
+	return isProbe(p)
+}
+
+// Path: /healthz
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	writeHealth(w, Health{Status: "ok"})
+}
+
+// Path: /readyz
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	writeHealth(w, app.ready())
+}
+
+// ready runs the readiness checks. The App is ready once its templates are
+// compiled, the instance type catalog is loaded, sessions can be stored and,
+// if ReadyEndpoint is set, the endpoint can be reached.
+func (app *App) ready() Health {
+	checks := []readyCheck{
+		{"templates", app.checkTemplates},
+		{"catalog", app.checkCatalog},
+		{"sessions", app.checkSessions},
+	}
+	if app.ReadyEndpoint != "" {
+		checks = append(checks, readyCheck{"endpoint", app.checkEndpoint})
+	}
+	health := Health{Status: "ok"}
+	for _, c := range checks {
+		result := Check{Name: c.name, OK: true}
+		if err := c.run(); err != nil {
+			result.OK = false
+			result.Error = err.Error()
+			health.Status = "unavailable"
+		}
+		health.Checks = append(health.Checks, result)
+	}
+	return health
+}
+
+func (app *App) checkTemplates() error {
+	for _, name := range []string{"index.html", "login.html", "404.html", "500.html"} {
+		if _, ok := app.tmpl[name]; !ok {
+			return fmt.Errorf("template %s is not compiled", name)
+		}
+	}
+	return nil
+}
+
+// checkCatalog reports if the instance type catalog has been loaded. It
+// never scrapes the catalog, which would make probes slow.
+func (app *App) checkCatalog() error {
+	return app.catalog.loaded()
+}
+
+// checkSessions round trips a value through the session store's codecs, as
+// every request's session cookie is.
+func (app *App) checkSessions() error {
+	codecs := app.store.Codecs
+	encoded, err := securecookie.EncodeMulti("yhat-resize", "ready", codecs...)
+	if err != nil {
+		return err
+	}
+	var value string
+	return securecookie.DecodeMulti("yhat-resize", encoded, &value, codecs...)
+}
+
+// checkEndpoint requires any HTTP response from ReadyEndpoint; an error
+// status still shows it can be reached.
+func (app *App) checkEndpoint() error {
+	client := &http.Client{Timeout: readyTimeout}
+	if app.HTTPClient != nil {
+		client.Transport = app.HTTPClient.Transport
+	}
+	resp, err := client.Get(app.ReadyEndpoint)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, health Health) {
+	b, err := json.Marshal(health)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if health.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
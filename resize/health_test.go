@@ -0,0 +1,124 @@
+package resize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.BasePath = "/tools/resize"
+	// avoid scraping the instance type catalog
+	app.catalog.types = []InstanceType{{}}
+	app.catalog.fetched = time.Now()
+
+	endpoint := httptest.NewServer(http.NotFoundHandler())
+	defer endpoint.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		path     string
+		endpoint string
+		status   int
+		failed   string
+	}{
+		{"/healthz", "", http.StatusOK, ""},
+		{"/tools/resize/healthz", "", http.StatusOK, ""},
+		{"/readyz", "", http.StatusOK, ""},
+		{"/tools/resize/readyz", endpoint.URL, http.StatusOK, ""},
+		{"/readyz", closed.URL, http.StatusServiceUnavailable, "endpoint"},
+	}
+	for _, test := range tests {
+		app.ReadyEndpoint = test.endpoint
+		r, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !app.IsProbe(r) {
+			t.Errorf("%s: expected a health check", test.path)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.path, test.status, w.Code, w.Body)
+			continue
+		}
+		var health Health
+		if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+			t.Errorf("%s: decoding response: %v", test.path, err)
+			continue
+		}
+		for _, check := range health.Checks {
+			if check.OK == (check.Name == test.failed) {
+				t.Errorf("%s: unexpected result of check %s: %+v", test.path, check.Name, check)
+			}
+		}
+	}
+
+	r, err := http.NewRequest("GET", "/tools/resize/about", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.IsProbe(r) {
+		t.Error("expected /about not to be a health check")
+	}
+}
+
+func TestReadyTemplates(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(app.tmpl, "login.html")
+	if err := app.checkTemplates(); err == nil {
+		t.Error("expected a missing template to fail the check")
+	}
+	if err := app.checkSessions(); err != nil {
+		t.Errorf("checking sessions: %v", err)
+	}
+}
+
+func TestReadyCatalog(t *testing.T) {
+	scraped := make(chan bool, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scraped <- true
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	defer func(url string) { instanceTypeURL = url }(instanceTypeURL)
+	instanceTypeURL = s.URL
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.HTTPClient = &http.Client{}
+	if err := app.checkCatalog(); err == nil {
+		t.Error("expected an unloaded catalog not to be ready")
+	}
+	select {
+	case <-scraped:
+		t.Error("the readiness check scraped the catalog")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	app.LoadInstanceTypes()
+	select {
+	case <-scraped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the catalog to be scraped on startup")
+	}
+	app.catalog.mu.Lock()
+	app.catalog.types = []InstanceType{{}}
+	app.catalog.mu.Unlock()
+	if err := app.checkCatalog(); err != nil {
+		t.Errorf("expected a loaded catalog to be ready, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package resize
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// maxHistory is the number of entries kept by the App's history.
+const maxHistory = 1000
+
+// HistoryEntry records an action taken by a user through the App.
+type HistoryEntry struct {
+	Time       time.Time
+	Account    string
+	User       string
+	Region     string
+	InstanceId string
+	JobId      string
+	Action     string
+	Message    string
+}
+
+// history is an in memory, fixed size log of actions, safe for concurrent use.
+type history struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+}
+
+func (h *history) add(e HistoryEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	if n := len(h.entries); n > maxHistory {
+		h.entries = append([]HistoryEntry{}, h.entries[n-maxHistory:]...)
+	}
+}
+
+// list returns the entries recorded for the account, newest first.
+func (h *history) list(account string) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := []HistoryEntry{}
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if e := h.entries[i]; e.Account == account {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// record adds an entry to the history of the account the credentials
+// belong to.
+func (app *App) record(ec2Cli *ec2.EC2, e HistoryEntry) {
+	e.Account = app.identity(ec2Cli).Account
+	app.history.add(e)
+}
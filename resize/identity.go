@@ -0,0 +1,201 @@
+package resize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// stsEndpoint is the global AWS STS endpoint, a variable so tests can use a
+// stand-in.
+var stsEndpoint = "https://sts.amazonaws.com/"
+
+// Identity is who a set of credentials belongs to. Approvals, history and
+// jobs are scoped by Account, and Principal tells the users of an account
+// apart whichever of their access keys they log in with.
+type Identity struct {
+	Account   string
+	Principal string
+}
+
+// identities caches the Identity of each access key, keyed by identityKey,
+// safe for concurrent use.
+type identities struct {
+	mu sync.Mutex
+	m  map[string]Identity
+}
+
+func newIdentities() *identities {
+	return &identities{m: make(map[string]Identity)}
+}
+
+func (ids *identities) get(key string) (Identity, bool) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	id, ok := ids.m[key]
+	return id, ok
+}
+
+func (ids *identities) set(key string, id Identity) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.m[key] = id
+}
+
+// identityKey keys the identity cache by where the credentials are used as
+// well as their access key, as the same access key ID on AWS and on each
+// provider may belong to different accounts.
+func (app *App) identityKey(ec2Cli *ec2.EC2) string {
+	if p, ok := app.provider(ec2Cli.Region.Name); ok {
+		return "provider:" + p.Name + "/" + user(ec2Cli)
+	}
+	return "aws/" + user(ec2Cli)
+}
+
+// identify returns the Identity of the credentials, asking AWS STS the
+// first time they are seen. Private clouds have no STS, so each provider is
+// treated as one account whose users are told apart by access key.
+func (app *App) identify(ec2Cli *ec2.EC2) (Identity, error) {
+	key := app.identityKey(ec2Cli)
+	if id, ok := app.identities.get(key); ok {
+		return id, nil
+	}
+	var id Identity
+	if p, ok := app.provider(ec2Cli.Region.Name); ok {
+		id = Identity{Account: "provider:" + p.Name, Principal: user(ec2Cli)}
+	} else {
+		var err error
+		id, err = callerIdentity(app.httpClient(), ec2Cli.Auth)
+		if err != nil {
+			return Identity{}, fmt.Errorf("identifying credentials: %v", err)
+		}
+	}
+	app.identities.set(key, id)
+	return id, nil
+}
+
+// identity returns the Identity of credentials from a logged in session,
+// which were identified when the session was checked.
+func (app *App) identity(ec2Cli *ec2.EC2) Identity {
+	id, _ := app.identities.get(app.identityKey(ec2Cli))
+	return id
+}
+
+// callerIdentity calls the STS GetCallerIdentity action, which any
+// credentials may call.
+func callerIdentity(client *http.Client, auth aws.Auth) (Identity, error) {
+	body := url.Values{
+		"Action":  {"GetCallerIdentity"},
+		"Version": {"2011-06-15"},
+	}.Encode()
+	req, err := http.NewRequest("POST", stsEndpoint, strings.NewReader(body))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, []byte(body), auth, "us-east-1", "sts", time.Now())
+	resp, err := client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Identity{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+			return Identity{}, fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+		return Identity{}, fmt.Errorf("bad response from STS: %s", resp.Status)
+	}
+	var result struct {
+		Arn     string `xml:"GetCallerIdentityResult>Arn"`
+		Account string `xml:"GetCallerIdentityResult>Account"`
+	}
+	if err := xml.Unmarshal(b, &result); err != nil {
+		return Identity{}, err
+	}
+	if result.Account == "" || result.Arn == "" {
+		return Identity{}, fmt.Errorf("STS returned no identity")
+	}
+	return Identity{Account: result.Account, Principal: result.Arn}, nil
+}
+
+// signV4 signs a request using AWS signature version 4.
+func signV4(req *http.Request, body []byte, auth aws.Auth, region, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if auth.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", auth.Token)
+	}
+	req.Header.Set("Host", req.URL.Host)
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var headers []string
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(value))
+	}
+	signed := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		strings.Join(headers, "\n") + "\n",
+		signed,
+		hexSHA256(body),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+auth.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Del("Host")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		auth.AccessKey, scope, signed, signature))
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,119 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// The example request from the AWS documentation of signature version 4.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	auth := aws.Auth{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, auth, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("expected Authorization header\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDALICE/") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>InvalidClientTokenId</Code><Message>bad key</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult>
+<Arn>arn:aws:iam::111122223333:user/alice</Arn><UserId>AIDA1</UserId><Account>111122223333</Account>
+</GetCallerIdentityResult></GetCallerIdentityResponse>`)
+	}))
+	defer s.Close()
+	defer func(endpoint string) { stsEndpoint = endpoint }(stsEndpoint)
+	stsEndpoint = s.URL + "/"
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.HTTPClient = &http.Client{}
+	app.Providers = []Provider{{Name: "private", Endpoint: s.URL}}
+
+	alice := ec2.New(aws.Auth{AccessKey: "AKIDALICE", SecretKey: "secret"}, aws.USEast)
+	for i := 0; i < 2; i++ {
+		id, err := app.identify(alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.Account != "111122223333" || id.Principal != "arn:aws:iam::111122223333:user/alice" {
+			t.Errorf("unexpected identity %+v", id)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the identity to be cached, STS was called %d times", calls)
+	}
+
+	mallory := ec2.New(aws.Auth{AccessKey: "AKIDMALLORY", SecretKey: "secret"}, aws.USEast)
+	if _, err := app.identify(mallory); err == nil || !strings.Contains(err.Error(), "InvalidClientTokenId") {
+		t.Errorf("expected an STS error, got %v", err)
+	}
+
+	private := ec2.New(aws.Auth{AccessKey: "AKIDBOB", SecretKey: "secret"}, aws.Region{Name: "private"})
+	if id, err := app.identify(private); err != nil || id.Account != "provider:private" || id.Principal != "AKIDBOB" {
+		t.Errorf("unexpected identity for a provider: %+v %v", id, err)
+	}
+
+	// the same access key ID on a provider is not the AWS account's
+	aliceOnProvider := ec2.New(aws.Auth{AccessKey: "AKIDALICE", SecretKey: "secret"}, aws.Region{Name: "private"})
+	if id, err := app.identify(aliceOnProvider); err != nil || id.Account != "provider:private" || id.Principal != "AKIDALICE" {
+		t.Errorf("unexpected identity for a provider: %+v %v", id, err)
+	}
+	if id := app.identity(alice); id.Account != "111122223333" {
+		t.Errorf("expected the AWS identity to be kept, got %+v", id)
+	}
+}
+
+func TestAccountScope(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := ec2.New(aws.Auth{AccessKey: "AKIDALICE"}, aws.USEast)
+	mallory := ec2.New(aws.Auth{AccessKey: "AKIDMALLORY"}, aws.USEast)
+	app.identities.set(app.identityKey(alice), Identity{Account: "111", Principal: "arn:aws:iam::111:user/alice"})
+	app.identities.set(app.identityKey(mallory), Identity{Account: "222", Principal: "arn:aws:iam::222:user/mallory"})
+
+	app.record(alice, HistoryEntry{User: "AKIDALICE", InstanceId: "i-1", Action: "resize"})
+	if entries := app.history.list("111"); len(entries) != 1 {
+		t.Errorf("expected the account's history entry, got %v", entries)
+	}
+	if entries := app.history.list(app.identity(mallory).Account); len(entries) != 0 {
+		t.Errorf("expected no history for another account, got %v", entries)
+	}
+
+	job, err := app.jobs.start("111", "us-east-1", "i-1", "AKIDALICE", "m3.large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.jobs.get("111", job.Id); !ok {
+		t.Error("expected the account's job to be found")
+	}
+	if _, ok := app.jobs.get("222", job.Id); ok {
+		t.Error("expected another account's job not to be found")
+	}
+}
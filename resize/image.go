@@ -0,0 +1,206 @@
+package resize
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+var (
+	imagePollInterval = 15 * time.Second
+	imageTimeout      = 2 * time.Hour
+)
+
+// createImage creates an AMI of a stopped instance without rebooting it and
+// tags it with the job ID. It blocks until the image is available.
+func createImage(ec2Cli *ec2.EC2, w io.Writer, instance ec2.Instance, jobId string) (string, error) {
+	opts := ec2.CreateImage{
+		InstanceId: instance.InstanceId,
+		Name:       fmt.Sprintf("pre-resize-%s-%s", instance.InstanceId, jobId),
+		Description: fmt.Sprintf("image of %s (%s) taken before resize job %s",
+			instance.InstanceId, instance.InstanceType, jobId),
+		NoReboot: true,
+	}
+	resp, err := ec2Cli.CreateImage(&opts)
+	if err != nil {
+		return "", fmt.Errorf("could not create image: %v", err)
+	}
+	imageId := resp.ImageId
+	sendEvent(w, Event{Status: "progress", Message: "creating image " + imageId})
+
+	tags := []ec2.Tag{
+		{Key: jobTag, Value: jobId},
+		{Key: "resize-instance", Value: instance.InstanceId},
+	}
+	if _, err := ec2Cli.CreateTags([]string{imageId}, tags); err != nil {
+		return imageId, fmt.Errorf("could not tag image: %v", err)
+	}
+	return imageId, waitForImage(ec2Cli, w, imageId)
+}
+
+// waitForImage polls until an image is available.
+func waitForImage(ec2Cli *ec2.EC2, w io.Writer, imageId string) error {
+	deadline := time.Now().Add(imageTimeout)
+	for time.Now().Before(deadline) {
+		resp, err := ec2Cli.Images([]string{imageId}, nil)
+		if err != nil {
+			return fmt.Errorf("error checking image status: %v", err)
+		}
+		if len(resp.Images) == 1 {
+			switch state := resp.Images[0].State; state {
+			case "available":
+				sendEvent(w, Event{Status: "progress", Message: "image " + imageId + " available"})
+				return nil
+			case "failed", "invalid", "deregistered", "error":
+				return fmt.Errorf("image %s is %s", imageId, state)
+			default:
+				sendEvent(w, Event{Status: "progress", Message: "image " + imageId + " " + state})
+			}
+		}
+		time.Sleep(imagePollInterval)
+	}
+	return fmt.Errorf("timed out waiting for image %s to become available", imageId)
+}
+
+// replacementOptions builds the options to launch a replacement for an
+// instance from an image, using the instance's original type and placement.
+func replacementOptions(original ec2.Instance, imageId string) *ec2.RunInstances {
+	opts := &ec2.RunInstances{
+		ImageId:            imageId,
+		MinCount:           1,
+		MaxCount:           1,
+		KeyName:            original.KeyName,
+		InstanceType:       original.InstanceType,
+		PlacementGroupName: original.PlacementGroupName,
+		SubnetId:           original.SubnetId,
+		EbsOptimized:       original.EbsOptimized == "true",
+		Tenancy:            original.Tenancy,
+	}
+	if original.SubnetId == "" {
+		opts.AvailZone = original.AvailZone
+	}
+	opts.IamInstanceProfile = instanceProfileName(original.IamInstanceProfile)
+	for _, group := range original.SecurityGroups {
+		if original.VpcId != "" {
+			// instances in a VPC must reference groups by ID
+			opts.SecurityGroups = append(opts.SecurityGroups, ec2.SecurityGroup{Id: group.Id})
+		} else {
+			opts.SecurityGroups = append(opts.SecurityGroups, ec2.SecurityGroup{Name: group.Name})
+		}
+	}
+	return opts
+}
+
+// instanceProfileName returns the name of an instance profile from its ARN,
+// as RunInstances takes the profile by name.
+func instanceProfileName(arn string) string {
+	arn = strings.TrimSpace(arn)
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// Path: /jobs/{job}
+func (app *App) handleJob(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	job, ok := app.jobs.get(app.identity(ec2Cli).Account, mux.Vars(r)["job"])
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+	data := map[string]interface{}{"Job": job}
+	app.render(w, r, "job.html", data)
+}
+
+// Path: /jobs/{job}/recover
+func (app *App) handleRecover(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	job, ok := app.jobs.get(app.identity(ec2Cli).Account, mux.Vars(r)["job"])
+	if !ok {
+		http.Error(w, "No such job", http.StatusNotFound)
+		return
+	}
+	if job.State != JobFailed {
+		http.Error(w, "Only failed jobs can be recovered, this job is "+string(job.State), http.StatusBadRequest)
+		return
+	}
+	if job.ImageId == "" || job.Original == nil {
+		http.Error(w, "No pre-resize image was taken for this job", http.StatusBadRequest)
+		return
+	}
+	if job.Region != ec2Cli.Region.Name {
+		http.Error(w, "Switch to region "+job.Region+" to recover this job", http.StatusBadRequest)
+		return
+	}
+	if job.Replacement != "" {
+		http.Error(w, "A replacement has already been launched: "+job.Replacement, http.StatusBadRequest)
+		return
+	}
+
+	// claim the job before launching, so concurrent requests cannot both
+	// launch a replacement
+	claimed := false
+	app.jobs.update(job.Id, func(job *Job) {
+		if job.Recoverable() {
+			job.Recovering = true
+			claimed = true
+		}
+	})
+	if !claimed {
+		http.Error(w, "This job is already being recovered", http.StatusConflict)
+		return
+	}
+
+	resp, err := ec2Cli.RunInstances(replacementOptions(*job.Original, job.ImageId))
+	if err == nil && len(resp.Instances) != 1 {
+		err = fmt.Errorf("expected 1 instance to be launched, got %d", len(resp.Instances))
+	}
+	if err != nil {
+		app.jobs.update(job.Id, func(job *Job) { job.Recovering = false })
+		app.render500(w, r, fmt.Errorf("could not launch replacement: %v", err))
+		return
+	}
+	replacement := resp.Instances[0].InstanceId
+	app.jobs.update(job.Id, func(job *Job) {
+		job.Replacement = replacement
+		job.Recovering = false
+	})
+
+	tags := []ec2.Tag{{Key: jobTag, Value: job.Id}, {Key: "resize-replaces", Value: job.InstanceId}}
+	for _, tag := range job.Original.Tags {
+		if tag.Key == "Name" {
+			tags = append(tags, tag)
+		}
+	}
+	if _, err := ec2Cli.CreateTags([]string{replacement}, tags); err != nil {
+		app.Logf("could not tag replacement instance %s: %v", replacement, err)
+	}
+	app.record(ec2Cli, HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     job.Region,
+		InstanceId: job.InstanceId,
+		JobId:      job.Id,
+		Action:     "recover",
+		Message:    fmt.Sprintf("launched replacement %s from image %s", replacement, job.ImageId),
+	})
+	http.Redirect(w, r, app.url("/instance/"+replacement), http.StatusSeeOther)
+}
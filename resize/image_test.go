@@ -0,0 +1,194 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+// imageServer is a stand-in for the EC2 image calls. Images become
+// available after being described twice.
+type imageServer struct {
+	mu        sync.Mutex
+	described int
+	noReboot  string
+	profile   string
+	tags      map[string]string
+}
+
+func (s *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	switch q.Get("Action") {
+	case "CreateImage":
+		s.noReboot = q.Get("NoReboot")
+		fmt.Fprint(w, `<CreateImageResponse><requestId>r</requestId><imageId>ami-1</imageId></CreateImageResponse>`)
+	case "CreateTags":
+		s.tags[q.Get("ResourceId.1")] = q.Get("Tag.1.Value")
+		fmt.Fprint(w, `<CreateTagsResponse><requestId>r</requestId><return>true</return></CreateTagsResponse>`)
+	case "DescribeImages":
+		s.described++
+		state := "pending"
+		if s.described > 2 {
+			state = "available"
+		}
+		fmt.Fprintf(w, `<DescribeImagesResponse><requestId>r</requestId><imagesSet>
+<item><imageId>%s</imageId><imageState>%s</imageState></item></imagesSet></DescribeImagesResponse>`, q.Get("ImageId.1"), state)
+	case "RunInstances":
+		s.profile = q.Get("IamInstanceProfile.Name")
+		fmt.Fprintf(w, `<RunInstancesResponse><requestId>r</requestId><instancesSet>
+<item><instanceId>i-5678</instanceId><imageId>%s</imageId></item></instancesSet></RunInstancesResponse>`, q.Get("ImageId"))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestCreateImage(t *testing.T) {
+	defer func(d time.Duration) { imagePollInterval = d }(imagePollInterval)
+	imagePollInterval = time.Millisecond
+
+	srv := &imageServer{tags: make(map[string]string)}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	buf := new(bytes.Buffer)
+	id, err := createImage(ec2Cli, buf, ec2.Instance{InstanceId: "i-1234"}, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "ami-1" {
+		t.Errorf("expected image ami-1, got %q", id)
+	}
+	if srv.noReboot != "true" {
+		t.Errorf("image should be created with NoReboot")
+	}
+	if srv.tags[id] != "job1" {
+		t.Errorf("image not tagged with job ID")
+	}
+	if srv.described < 3 {
+		t.Errorf("did not wait for image to become available")
+	}
+}
+
+func TestReplacementOptions(t *testing.T) {
+	vpc := ec2.Instance{
+		InstanceType:   "m3.large",
+		KeyName:        "key",
+		SubnetId:       "subnet-1",
+		VpcId:          "vpc-1",
+		AvailZone:      "us-east-1a",
+		EbsOptimized:   "true",
+		SecurityGroups: []ec2.SecurityGroup{{Id: "sg-1", Name: "web"}},
+	}
+	opts := replacementOptions(vpc, "ami-1")
+	if opts.ImageId != "ami-1" || opts.InstanceType != "m3.large" || opts.SubnetId != "subnet-1" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if opts.AvailZone != "" {
+		t.Errorf("availability zone should come from the subnet")
+	}
+	if !opts.EbsOptimized {
+		t.Errorf("expected EBS optimized")
+	}
+	if len(opts.SecurityGroups) != 1 || opts.SecurityGroups[0].Id != "sg-1" || opts.SecurityGroups[0].Name != "" {
+		t.Errorf("VPC security groups must be referenced by ID, got %v", opts.SecurityGroups)
+	}
+
+	classic := ec2.Instance{
+		InstanceType:   "m1.small",
+		AvailZone:      "us-east-1a",
+		SecurityGroups: []ec2.SecurityGroup{{Id: "sg-1", Name: "web"}},
+	}
+	opts = replacementOptions(classic, "ami-1")
+	if opts.AvailZone != "us-east-1a" {
+		t.Errorf("expected availability zone us-east-1a, got %q", opts.AvailZone)
+	}
+	if len(opts.SecurityGroups) != 1 || opts.SecurityGroups[0].Name != "web" || opts.SecurityGroups[0].Id != "" {
+		t.Errorf("classic security groups must be referenced by name, got %v", opts.SecurityGroups)
+	}
+}
+
+func TestReplacementProfile(t *testing.T) {
+	srv := &imageServer{tags: make(map[string]string)}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	original := ec2.Instance{
+		InstanceType:       "m3.large",
+		IamInstanceProfile: "arn:aws:iam::111:instance-profile/web/web-server",
+	}
+	if _, err := ec2Cli.RunInstances(replacementOptions(original, "ami-1")); err != nil {
+		t.Fatal(err)
+	}
+	if srv.profile != "web-server" {
+		t.Errorf("expected instance profile web-server, got %q", srv.profile)
+	}
+
+	if _, err := ec2Cli.RunInstances(replacementOptions(ec2.Instance{InstanceType: "m3.large"}, "ami-1")); err != nil {
+		t.Fatal(err)
+	}
+	if srv.profile != "" {
+		t.Errorf("expected no instance profile, got %q", srv.profile)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test", EC2Endpoint: srv.URL()}
+	s := loginSession(t, app, "AKIDALICE", region)
+
+	newJob := func(err error) string {
+		job, _ := app.jobs.start("111", region.Name, "i-1", "AKIDALICE", "m3.large")
+		app.jobs.update(job.Id, func(job *Job) {
+			job.ImageId = "ami-1"
+			job.Original = &ec2.Instance{InstanceId: "i-1", InstanceType: "m3.medium"}
+		})
+		app.jobs.finish(job.Id, err)
+		return job.Id
+	}
+	recoverJob := func(id string) int {
+		return s.do("POST", "/jobs/"+id+"/recover", url.Values{}).Code
+	}
+
+	if code := recoverJob(newJob(nil)); code != http.StatusBadRequest {
+		t.Errorf("expected a succeeded job not to be recovered, got %d", code)
+	}
+
+	failed := newJob(fmt.Errorf("instance did not start"))
+	app.jobs.update(failed, func(job *Job) { job.Recovering = true })
+	if code := recoverJob(failed); code != http.StatusConflict {
+		t.Errorf("expected a job being recovered not to be recovered again, got %d", code)
+	}
+	app.jobs.update(failed, func(job *Job) { job.Recovering = false })
+
+	if code := recoverJob(failed); code != http.StatusSeeOther {
+		t.Fatalf("expected the failed job to be recovered, got %d", code)
+	}
+	job, _ := app.jobs.get("111", failed)
+	if job.Replacement == "" || job.Recovering {
+		t.Errorf("expected the replacement to be recorded, got %+v", job)
+	}
+	if code := recoverJob(failed); code != http.StatusBadRequest {
+		t.Errorf("expected only one replacement to be launched, got %d", code)
+	}
+}
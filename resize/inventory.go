@@ -0,0 +1,277 @@
+package resize
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// instanceStates are the states an instance can be in, in lifecycle order.
+var instanceStates = []string{"pending", "running", "stopping", "stopped", "shutting-down", "terminated"}
+
+// sortKeys maps the sort parameter to a function comparing two instances.
+var sortKeys = map[string]func(a, b ec2.Instance) bool{
+	"id":     func(a, b ec2.Instance) bool { return a.InstanceId < b.InstanceId },
+	"name":   func(a, b ec2.Instance) bool { return tagValue(a.Tags, "Name") < tagValue(b.Tags, "Name") },
+	"state":  func(a, b ec2.Instance) bool { return a.State.Name < b.State.Name },
+	"type":   func(a, b ec2.Instance) bool { return a.InstanceType < b.InstanceType },
+	"launch": func(a, b ec2.Instance) bool { return a.LaunchTime.Before(b.LaunchTime) },
+	"az":     func(a, b ec2.Instance) bool { return a.AvailZone < b.AvailZone },
+}
+
+// tagValue returns the value of the tag with the given key.
+func tagValue(tags []ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// InventoryQuery describes which instances the index page lists and how.
+// It round trips through the URL query so views can be shared.
+type InventoryQuery struct {
+	State    string
+	Type     string
+	TagKey   string
+	TagValue string
+	VPC      string
+	AZ       string
+	Name     string
+
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
+}
+
+// ParseInventoryQuery reads a query from URL values, using defaults for
+// anything missing.
+func ParseInventoryQuery(v url.Values) (InventoryQuery, error) {
+	q := InventoryQuery{
+		State:    v.Get("state"),
+		Type:     v.Get("type"),
+		TagKey:   v.Get("tag-key"),
+		TagValue: v.Get("tag-value"),
+		VPC:      v.Get("vpc"),
+		AZ:       v.Get("az"),
+		Name:     v.Get("name"),
+		Sort:     v.Get("sort"),
+		Desc:     v.Get("desc") == "true",
+		Page:     1,
+		PerPage:  defaultPerPage,
+	}
+	if q.Sort == "" {
+		q.Sort = "name"
+	}
+	if _, ok := sortKeys[q.Sort]; !ok {
+		return q, fmt.Errorf("cannot sort by %q", q.Sort)
+	}
+	if q.TagValue != "" && q.TagKey == "" {
+		return q, fmt.Errorf("a tag value requires a tag key")
+	}
+	if s := v.Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 {
+			return q, fmt.Errorf("invalid page %q", s)
+		}
+		q.Page = page
+	}
+	if s := v.Get("per-page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPerPage {
+			return q, fmt.Errorf("per-page must be between 1 and %d", maxPerPage)
+		}
+		q.PerPage = n
+	}
+	return q, nil
+}
+
+// Values encodes the query, omitting defaults.
+func (q InventoryQuery) Values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("state", q.State)
+	set("type", q.Type)
+	set("tag-key", q.TagKey)
+	set("tag-value", q.TagValue)
+	set("vpc", q.VPC)
+	set("az", q.AZ)
+	set("name", q.Name)
+	if q.Sort != "name" {
+		set("sort", q.Sort)
+	}
+	if q.Desc {
+		v.Set("desc", "true")
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PerPage != defaultPerPage && q.PerPage != 0 {
+		v.Set("per-page", strconv.Itoa(q.PerPage))
+	}
+	return v
+}
+
+// With returns the URL of the index page, relative to the base path, with
+// one query parameter changed. Changing anything other than the page returns
+// to the first page.
+func (q InventoryQuery) With(key, value string) string {
+	v := q.Values()
+	if key != "page" {
+		v.Del("page")
+	}
+	if value == "" {
+		v.Del(key)
+	} else {
+		v.Set(key, value)
+	}
+	if len(v) == 0 {
+		return "./"
+	}
+	return "./?" + v.Encode()
+}
+
+// SortBy returns the URL sorting by key, reversing the order if the query
+// is already sorted by it.
+func (q InventoryQuery) SortBy(key string) string {
+	next := q
+	next.Sort = key
+	next.Desc = q.Sort == key && !q.Desc
+	next.Page = 1
+	if len(next.Values()) == 0 {
+		return "./"
+	}
+	return "./?" + next.Values().Encode()
+}
+
+// Filtered reports if any filters are set.
+func (q InventoryQuery) Filtered() bool {
+	return len(q.filters()) > 0
+}
+
+// filters returns the EC2 DescribeInstances filters for the query.
+func (q InventoryQuery) filters() map[string]string {
+	f := make(map[string]string)
+	if q.State != "" {
+		f["instance-state-name"] = q.State
+	}
+	if q.Type != "" {
+		f["instance-type"] = q.Type
+	}
+	if q.TagKey != "" {
+		if q.TagValue != "" {
+			f["tag:"+q.TagKey] = q.TagValue
+		} else {
+			f["tag-key"] = q.TagKey
+		}
+	}
+	if q.VPC != "" {
+		f["vpc-id"] = q.VPC
+	}
+	if q.AZ != "" {
+		f["availability-zone"] = q.AZ
+	}
+	if q.Name != "" {
+		f["tag:Name"] = "*" + q.Name + "*"
+	}
+	return f
+}
+
+// Filter returns the query's filters as an ec2.Filter, or nil if there are
+// none.
+func (q InventoryQuery) Filter() *ec2.Filter {
+	filters := q.filters()
+	if len(filters) == 0 {
+		return nil
+	}
+	filter := ec2.NewFilter()
+	for name, value := range filters {
+		filter.Add(name, value)
+	}
+	return filter
+}
+
+// InventoryPage is one page of a sorted list of instances.
+type InventoryPage struct {
+	Instances []ec2.Instance
+	Total     int
+	Page      int
+	Pages     int
+}
+
+// HasPrev reports if there is a page before this one.
+func (p InventoryPage) HasPrev() bool { return p.Page > 1 }
+
+// HasNext reports if there is a page after this one.
+func (p InventoryPage) HasNext() bool { return p.Page < p.Pages }
+
+// Prev is the number of the previous page.
+func (p InventoryPage) Prev() string { return strconv.Itoa(p.Page - 1) }
+
+// Next is the number of the next page.
+func (p InventoryPage) Next() string { return strconv.Itoa(p.Page + 1) }
+
+// page sorts instances and returns the page the query asks for. Terminated
+// instances are hidden unless the query filters on state.
+func (q InventoryQuery) page(instances []ec2.Instance) InventoryPage {
+	var shown []ec2.Instance
+	for _, instance := range instances {
+		if q.State == "" && instance.State.Name == "terminated" {
+			continue
+		}
+		shown = append(shown, instance)
+	}
+	sort.Stable(byKey{shown, sortKeys[q.Sort], q.Desc})
+
+	perPage := q.PerPage
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	p := InventoryPage{Total: len(shown), Page: q.Page}
+	p.Pages = (len(shown) + perPage - 1) / perPage
+	if p.Pages == 0 {
+		p.Pages = 1
+	}
+	if p.Page > p.Pages {
+		p.Page = p.Pages
+	}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	start := (p.Page - 1) * perPage
+	end := start + perPage
+	if end > len(shown) {
+		end = len(shown)
+	}
+	p.Instances = shown[start:end]
+	return p
+}
+
+type byKey struct {
+	instances []ec2.Instance
+	less      func(a, b ec2.Instance) bool
+	desc      bool
+}
+
+func (s byKey) Len() int      { return len(s.instances) }
+func (s byKey) Swap(i, j int) { s.instances[i], s.instances[j] = s.instances[j], s.instances[i] }
+func (s byKey) Less(i, j int) bool {
+	if s.desc {
+		return s.less(s.instances[j], s.instances[i])
+	}
+	return s.less(s.instances[i], s.instances[j])
+}
@@ -0,0 +1,122 @@
+package resize
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestParseInventoryQuery(t *testing.T) {
+	v, _ := url.ParseQuery("state=running&tag-key=Owner&tag-value=ops&name=web&sort=type&desc=true&page=2&per-page=10")
+	q, err := ParseInventoryQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"instance-state-name": "running",
+		"tag:Owner":           "ops",
+		"tag:Name":            "*web*",
+	}
+	filters := q.filters()
+	if len(filters) != len(want) {
+		t.Errorf("expected filters %v, got %v", want, filters)
+	}
+	for name, value := range want {
+		if filters[name] != value {
+			t.Errorf("filter %s: expected %q, got %q", name, value, filters[name])
+		}
+	}
+	if q.Sort != "type" || !q.Desc || q.Page != 2 || q.PerPage != 10 {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if got := q.Values().Encode(); got != v.Encode() {
+		t.Errorf("query does not round trip: expected %s, got %s", v.Encode(), got)
+	}
+
+	bad := []string{"sort=color", "tag-value=ops", "page=0", "page=x", "per-page=100000"}
+	for _, s := range bad {
+		v, _ := url.ParseQuery(s)
+		if _, err := ParseInventoryQuery(v); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestInventoryQueryURLs(t *testing.T) {
+	q, _ := ParseInventoryQuery(url.Values{})
+	if got := q.With("state", "running"); got != "./?state=running" {
+		t.Errorf("unexpected URL %s", got)
+	}
+	if got := q.SortBy("name"); got != "./?desc=true" {
+		t.Errorf("sorting by the current key should reverse it, got %s", got)
+	}
+	q.Page = 3
+	if got := q.With("type", "m3.large"); got != "./?type=m3.large" {
+		t.Errorf("changing a filter should return to the first page, got %s", got)
+	}
+	if got := q.With("page", "4"); got != "./?page=4" {
+		t.Errorf("unexpected URL %s", got)
+	}
+}
+
+func TestInventoryPage(t *testing.T) {
+	base := time.Date(2015, 10, 1, 0, 0, 0, 0, time.UTC)
+	var instances []ec2.Instance
+	for i := 0; i < 25; i++ {
+		state := "running"
+		if i%5 == 0 {
+			state = "terminated"
+		}
+		instances = append(instances, ec2.Instance{
+			InstanceId: fmt.Sprintf("i-%02d", i),
+			State:      ec2.InstanceState{Name: state},
+			LaunchTime: base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	q := InventoryQuery{Sort: "launch", Desc: true, Page: 2, PerPage: 8}
+	p := q.page(instances)
+	if p.Total != 20 || p.Pages != 3 || len(p.Instances) != 8 {
+		t.Fatalf("unexpected page %d of %d with %d of %d instances", p.Page, p.Pages, len(p.Instances), p.Total)
+	}
+	if p.Instances[0].InstanceId != "i-14" {
+		t.Errorf("expected page 2 to start with i-14, got %s", p.Instances[0].InstanceId)
+	}
+	if !p.HasPrev() || !p.HasNext() {
+		t.Errorf("expected previous and next pages")
+	}
+
+	q = InventoryQuery{State: "terminated", Sort: "id", Page: 9, PerPage: 8}
+	p = q.page(instances)
+	if p.Total != 25 || p.Page != 4 || len(p.Instances) != 1 {
+		t.Errorf("expected last page with terminated instances, got page %d with %d of %d", p.Page, len(p.Instances), p.Total)
+	}
+}
+
+func TestInventoryFilter(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: srv.URL()})
+
+	srv.NewInstances(2, "m3.large", "ami-1234", ec2test.Running, nil)
+	srv.NewInstances(1, "m3.large", "ami-1234", ec2test.Pending, nil)
+
+	q, _ := ParseInventoryQuery(url.Values{"state": {"running"}})
+	resp, err := ec2Cli.Instances(nil, q.Filter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(allInstances(resp)); n != 2 {
+		t.Errorf("expected 2 running instances, got %d", n)
+	}
+	if (InventoryQuery{}).Filter() != nil {
+		t.Errorf("expected no filter for an empty query")
+	}
+}
@@ -0,0 +1,143 @@
+package resize
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// maxJobs is the number of jobs kept by the App. Once there are more, the
+// oldest finished jobs are forgotten.
+const maxJobs = 1000
+
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Job is a resize of an instance, or a change of one of its volumes if
+// NewType is empty.
+type Job struct {
+	Id         string
+	Account    string
+	Region     string
+	InstanceId string
+	User       string
+	NewType    string
+	State      JobState
+	Error      string
+	Started    time.Time
+	Finished   time.Time
+
+	// Snapshots lists the IDs of snapshots taken before the resize.
+	Snapshots []string
+
+	// ImageId is the AMI created before the resize, if any. Original is the
+	// instance as it was described when the image was taken and is used to
+	// launch a replacement if the resize fails.
+	ImageId     string
+	Original    *ec2.Instance
+	Replacement string
+	// Recovering is set while a replacement is being launched, so only one
+	// is launched however many times recovery is requested.
+	Recovering bool
+}
+
+// Recoverable reports whether a replacement instance can be launched from
+// the job's pre-resize image.
+func (job Job) Recoverable() bool {
+	return job.State == JobFailed && job.ImageId != "" && job.Original != nil &&
+		job.Replacement == "" && !job.Recovering
+}
+
+// jobs holds jobs in memory, safe for concurrent use.
+type jobs struct {
+	mu sync.Mutex
+	m  map[string]*Job
+	// order holds the IDs of the jobs, oldest first.
+	order []string
+}
+
+func newJobs() *jobs {
+	return &jobs{m: make(map[string]*Job)}
+}
+
+// prune forgets the oldest finished jobs while there are more than maxJobs.
+// Running jobs are always kept. The caller must hold the lock.
+func (j *jobs) prune() {
+	excess := len(j.order) - maxJobs
+	if excess <= 0 {
+		return
+	}
+	kept := j.order[:0]
+	for _, id := range j.order {
+		if excess > 0 && j.m[id].State != JobRunning {
+			delete(j.m, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	j.order = kept
+}
+
+// start records a new running job for the account.
+func (j *jobs) start(account, region, instanceId, user, newType string) (Job, error) {
+	id, err := newId()
+	if err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		Id:         id,
+		Account:    account,
+		Region:     region,
+		InstanceId: instanceId,
+		User:       user,
+		NewType:    newType,
+		State:      JobRunning,
+		Started:    time.Now(),
+	}
+	j.mu.Lock()
+	j.m[id] = job
+	j.order = append(j.order, id)
+	j.prune()
+	j.mu.Unlock()
+	return *job, nil
+}
+
+// update applies f to the job with the given id.
+func (j *jobs) update(id string, f func(job *Job)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if job, ok := j.m[id]; ok {
+		f(job)
+	}
+}
+
+// finish marks a job as succeeded, or failed if err is not nil.
+func (j *jobs) finish(id string, err error) {
+	j.update(id, func(job *Job) {
+		job.Finished = time.Now()
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+		} else {
+			job.State = JobSucceeded
+		}
+	})
+}
+
+// get returns the job with the given id if it belongs to the account.
+func (j *jobs) get(account, id string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.m[id]
+	if !ok || job.Account != account {
+		return Job{}, false
+	}
+	return *job, true
+}
@@ -0,0 +1,24 @@
+package resize
+
+import "testing"
+
+func TestJobsPrune(t *testing.T) {
+	j := newJobs()
+	running, err := j.start("111", "us-east-1", "i-0", "alice", "m3.large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxJobs+10; i++ {
+		job, err := j.start("111", "us-east-1", "i-1", "alice", "m3.large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.finish(job.Id, nil)
+	}
+	if n := len(j.m); n != maxJobs {
+		t.Errorf("expected %d jobs to be kept, got %d", maxJobs, n)
+	}
+	if _, ok := j.get("111", running.Id); !ok {
+		t.Error("expected the running job to be kept")
+	}
+}
@@ -0,0 +1,216 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+	"golang.org/x/net/websocket"
+)
+
+// Instance state codes reported by EC2.
+const (
+	stateRunning      = 16
+	stateShuttingDown = 32
+	stateTerminated   = 48
+	stateStopped      = 80
+)
+
+var (
+	lifecyclePollInterval = 3 * time.Second
+	lifecycleTimeout      = 10 * time.Minute
+)
+
+// lifecycleRequest is the message sent by the client to change the state of
+// an instance. Terminating an instance requires Confirm to be its ID.
+type lifecycleRequest struct {
+	Action  string
+	Confirm string
+}
+
+func parseLifecycleRequest(msg, instanceId string) (lifecycleRequest, error) {
+	var req lifecycleRequest
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return req, fmt.Errorf("malformed request: %v", err)
+	}
+	switch req.Action {
+	case "start", "stop", "reboot":
+	case "terminate":
+		if req.Confirm != instanceId {
+			return req, fmt.Errorf("type the instance ID to confirm termination")
+		}
+	default:
+		return req, fmt.Errorf("unknown action %q", req.Action)
+	}
+	return req, nil
+}
+
+// protected reports if an instance may not be stopped or terminated.
+func (app *App) protected(instance ec2.Instance) bool {
+	return app.ProtectedRule != nil && app.ProtectedRule.Match(instance.Tags)
+}
+
+// checkProtected returns an error if the instance is protected and not
+// stopped, as resizing it or changing its volumes would stop it.
+func (app *App) checkProtected(ec2Cli *ec2.EC2, instanceId string) error {
+	if app.ProtectedRule == nil {
+		return nil
+	}
+	resp, err := ec2Cli.Instances([]string{instanceId}, nil)
+	if err != nil {
+		return fmt.Errorf("Bad response from AWS %v", err)
+	}
+	instances := allInstances(resp)
+	if len(instances) != 1 {
+		return fmt.Errorf("instance %s not found", instanceId)
+	}
+	if instance := instances[0]; instance.State.Name != "stopped" && app.protected(instance) {
+		return fmt.Errorf("%s is protected by the tag %s and cannot be stopped", instanceId, app.ProtectedRule)
+	}
+	return nil
+}
+
+// waitForState polls until an instance reaches the state with the given
+// code, sending each state seen to w. It fails early if the instance is
+// shutting down or terminated while waiting for any other state.
+func waitForState(ec2Cli *ec2.EC2, w io.Writer, id string, want int) error {
+	deadline := time.Now().Add(lifecycleTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(lifecyclePollInterval)
+		opts := ec2.DescribeInstanceStatus{
+			InstanceIds:         []string{id},
+			IncludeAllInstances: true,
+		}
+		resp, err := ec2Cli.DescribeInstanceStatus(&opts, nil)
+		if err != nil {
+			return fmt.Errorf("error checking instance status: %v", err)
+		}
+		found := false
+		for _, status := range resp.InstanceStatus {
+			if status.InstanceId != id {
+				continue
+			}
+			found = true
+			sendEvent(w, Event{Status: "message", Message: status.InstanceState.Name})
+			switch code := status.InstanceState.Code; {
+			case code == want:
+				return nil
+			case want != stateTerminated && (code == stateShuttingDown || code == stateTerminated):
+				return fmt.Errorf("instance %s is %s", id, status.InstanceState.Name)
+			}
+		}
+		if !found {
+			// terminated instances soon disappear from the status list
+			if want == stateTerminated {
+				return nil
+			}
+			return fmt.Errorf("status of instance %s not available", id)
+		}
+	}
+	return fmt.Errorf("timed out waiting for instance to change state")
+}
+
+// changeState performs a lifecycle action and waits for it to complete.
+func changeState(ec2Cli *ec2.EC2, w io.Writer, instance ec2.Instance, action string) error {
+	id := instance.InstanceId
+	state := instance.State.Name
+	switch action {
+	case "start":
+		if state != "stopped" {
+			return fmt.Errorf("only stopped instances can be started, %s is %s", id, state)
+		}
+		if _, err := ec2Cli.StartInstances(id); err != nil {
+			return fmt.Errorf("error starting instance: %v", err)
+		}
+		return pollUntilRunning(ec2Cli, w, id)
+	case "stop":
+		if state != "running" {
+			return fmt.Errorf("only running instances can be stopped, %s is %s", id, state)
+		}
+		return stopAndWait(ec2Cli, w, id)
+	case "reboot":
+		if state != "running" {
+			return fmt.Errorf("only running instances can be rebooted, %s is %s", id, state)
+		}
+		if _, err := ec2Cli.RebootInstances(id); err != nil {
+			return fmt.Errorf("error rebooting instance: %v", err)
+		}
+		// a reboot happens in place without changing the instance state
+		sendEvent(w, Event{Status: "progress", Message: "reboot requested"})
+		return nil
+	case "terminate":
+		if state == "terminated" || state == "shutting-down" {
+			return fmt.Errorf("%s is already %s", id, state)
+		}
+		if _, err := ec2Cli.TerminateInstances([]string{id}); err != nil {
+			return fmt.Errorf("error terminating instance: %v", err)
+		}
+		return waitForState(ec2Cli, w, id, stateTerminated)
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+// Path: /instance/{instance}/lifecycle
+func (app *App) handleLifecycle(ws *websocket.Conn) {
+	defer ws.Close()
+
+	r := ws.Request()
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.wsErr(ws, "Unauthorized")
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	if instanceId == "" {
+		app.wsErr(ws, "No instance ID included")
+		return
+	}
+
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
+		app.wsErr(ws, fmt.Sprintf("error receiving websocket message: %v", err))
+		return
+	}
+	req, err := parseLifecycleRequest(msg, instanceId)
+	if err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+
+	resp, err := ec2Cli.Instances([]string{instanceId}, nil)
+	if err != nil {
+		app.wsErr(ws, fmt.Sprintf("Bad response from AWS %v", err))
+		return
+	}
+	instances := allInstances(resp)
+	if len(instances) != 1 {
+		app.wsErr(ws, fmt.Sprintf("instance %s not found", instanceId))
+		return
+	}
+	instance := instances[0]
+	if (req.Action == "stop" || req.Action == "terminate") && app.protected(instance) {
+		app.wsErr(ws, fmt.Sprintf("%s is protected by the tag %s and cannot be stopped or terminated",
+			instanceId, app.ProtectedRule))
+		return
+	}
+
+	entry := HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     ec2Cli.Region.Name,
+		InstanceId: instanceId,
+		Action:     req.Action,
+	}
+	if err := changeState(ec2Cli, ws, instance, req.Action); err != nil {
+		app.wsErr(ws, err.Error())
+		entry.Message = fmt.Sprintf("failed to %s: %v", req.Action, err)
+		app.record(ec2Cli, entry)
+		return
+	}
+	entry.Message = fmt.Sprintf("%s from %s", req.Action, instance.State.Name)
+	app.record(ec2Cli, entry)
+	e := Event{Status: "success"}
+	websocket.JSON.Send(ws, &e)
+}
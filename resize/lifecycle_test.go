@@ -0,0 +1,92 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestParseLifecycleRequest(t *testing.T) {
+	tests := []struct {
+		msg string
+		ok  bool
+	}{
+		{`{"Action":"start"}`, true},
+		{`{"Action":"stop"}`, true},
+		{`{"Action":"reboot"}`, true},
+		{`{"Action":"terminate","Confirm":"i-1234"}`, true},
+		{`{"Action":"terminate","Confirm":"i-9999"}`, false},
+		{`{"Action":"terminate"}`, false},
+		{`{"Action":"hibernate"}`, false},
+		{`stop`, false},
+	}
+	for _, test := range tests {
+		_, err := parseLifecycleRequest(test.msg, "i-1234")
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: expected ok=%t, got error %v", test.msg, test.ok, err)
+		}
+	}
+}
+
+func TestProtected(t *testing.T) {
+	instance := ec2.Instance{Tags: []ec2.Tag{{Key: "env", Value: "prod"}}}
+	if (&App{}).protected(instance) {
+		t.Errorf("no instances should be protected without a rule")
+	}
+	app := &App{ProtectedRule: &TagRule{Key: "env", Value: "prod"}}
+	if !app.protected(instance) {
+		t.Errorf("expected instance tagged env=prod to be protected")
+	}
+	if app.protected(ec2.Instance{Tags: []ec2.Tag{{Key: "env", Value: "dev"}}}) {
+		t.Errorf("expected instance tagged env=dev not to be protected")
+	}
+}
+
+func TestCheckProtected(t *testing.T) {
+	// i-1 is running and i-2 stopped, both tagged env=prod
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("InstanceId.1")
+		state := map[string]string{"i-1": "running", "i-2": "stopped"}[id]
+		fmt.Fprintf(w, `<DescribeInstancesResponse><requestId>r</requestId><reservationSet><item><reservationId>r-1</reservationId>
+<instancesSet><item><instanceId>%s</instanceId><instanceState><name>%s</name></instanceState>
+<tagSet><item><key>env</key><value>prod</value></item></tagSet></item></instancesSet></item></reservationSet></DescribeInstancesResponse>`, id, state)
+	}))
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	if err := (&App{}).checkProtected(ec2Cli, "i-1"); err != nil {
+		t.Errorf("no instances should be protected without a rule, got %v", err)
+	}
+	app := &App{ProtectedRule: &TagRule{Key: "env", Value: "prod"}}
+	if err := app.checkProtected(ec2Cli, "i-1"); err == nil {
+		t.Errorf("expected a running protected instance not to be stopped")
+	}
+	if err := app.checkProtected(ec2Cli, "i-2"); err != nil {
+		t.Errorf("expected a stopped protected instance to be changed, got %v", err)
+	}
+}
+
+func TestChangeStateRequiresState(t *testing.T) {
+	// these are rejected before any calls are made to AWS
+	tests := []struct {
+		state  string
+		action string
+	}{
+		{"running", "start"},
+		{"stopped", "stop"},
+		{"stopped", "reboot"},
+		{"terminated", "terminate"},
+		{"running", "hibernate"},
+	}
+	for _, test := range tests {
+		instance := ec2.Instance{InstanceId: "i-1234", State: ec2.InstanceState{Name: test.state}}
+		if err := changeState(nil, new(bytes.Buffer), instance, test.action); err == nil {
+			t.Errorf("expected error for %s of %s instance", test.action, test.state)
+		}
+	}
+}
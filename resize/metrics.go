@@ -0,0 +1,275 @@
+package resize
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics below are exposed at /metrics using the Prometheus text
+// exposition format.
+
+// counter is a monotonically increasing value, optionally partitioned by a
+// single label.
+type counter struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help, label string) *counter {
+	return &counter{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (c *counter) inc(labelValue string) {
+	c.mu.Lock()
+	c.values[labelValue]++
+	c.mu.Unlock()
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.values[""]))
+		return
+	}
+	for _, lv := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, labelPair(c.label, lv), formatFloat(c.values[lv]))
+	}
+}
+
+// gauge is a value which may go up or down.
+type gauge struct {
+	name, help string
+
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *gauge) add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+}
+
+// histogram counts observations into cumulative buckets, optionally
+// partitioned by a single label.
+type histogram struct {
+	name, help, label string
+	buckets           []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help, label string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+func (h *histogram) observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// since observes the seconds elapsed since start.
+func (h *histogram) since(labelValue string, start time.Time) {
+	h.observe(labelValue, time.Since(start).Seconds())
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	labels := make([]string, 0, len(h.series))
+	for lv := range h.series {
+		labels = append(labels, lv)
+	}
+	sort.Strings(labels)
+	for _, lv := range labels {
+		s := h.series[lv]
+		prefix := ""
+		if h.label != "" {
+			prefix = labelPair(h.label, lv) + ","
+		}
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, prefix, formatFloat(upper), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, prefix, s.count)
+		suffix := ""
+		if h.label != "" {
+			suffix = "{" + labelPair(h.label, lv) + "}"
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, suffix, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, suffix, s.count)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelPair(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	// buckets for AWS API calls
+	apiBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	// buckets for resize phases, which poll for minutes
+	phaseBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+)
+
+// metrics holds the App's instrumentation.
+type metrics struct {
+	resizesStarted   *counter
+	resizesSucceeded *counter
+	resizesFailed    *counter
+	phaseDuration    *histogram
+
+	awsCalls       *counter
+	awsCallErrors  *counter
+	awsCallLatency *histogram
+
+	scrapeFailures *counter
+	websockets     *gauge
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		resizesStarted: newCounter("resize_resizes_started_total",
+			"Resizes started.", ""),
+		resizesSucceeded: newCounter("resize_resizes_succeeded_total",
+			"Resizes which completed successfully.", ""),
+		resizesFailed: newCounter("resize_resizes_failed_total",
+			"Resizes which failed, by reason.", "reason"),
+		phaseDuration: newHistogram("resize_phase_duration_seconds",
+			"Duration of each phase of a resize.", "phase", phaseBuckets),
+		awsCalls: newCounter("resize_aws_calls_total",
+			"Calls made to the EC2 API, by operation.", "operation"),
+		awsCallErrors: newCounter("resize_aws_call_errors_total",
+			"Calls to the EC2 API which failed, by operation.", "operation"),
+		awsCallLatency: newHistogram("resize_aws_call_duration_seconds",
+			"Latency of calls to the EC2 API, by operation.", "operation", apiBuckets),
+		scrapeFailures: newCounter("resize_instance_type_scrape_failures_total",
+			"Failed attempts to scrape the instance type catalog.", ""),
+		websockets: &gauge{name: "resize_websocket_connections",
+			help: "Active websocket connections."},
+	}
+}
+
+// metricsTransport instruments every EC2 query API call passing through it.
+type metricsTransport struct {
+	metrics *metrics
+	base    http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := req.URL.Query().Get("Action")
+	if op == "" {
+		return t.base.RoundTrip(req)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.metrics.awsCallLatency.since(op, start)
+	t.metrics.awsCalls.inc(op)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.metrics.awsCallErrors.inc(op)
+	}
+	return resp, err
+}
+
+// instrumentClient returns a copy of client which records metrics for the
+// calls made with it.
+func (app *App) instrumentClient(client *http.Client) *http.Client {
+	if app.metrics == nil {
+		return client
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &metricsTransport{metrics: app.metrics, base: base}
+	return &c
+}
+
+// Path: /metrics
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	m := app.metrics
+	m.resizesStarted.write(bw)
+	m.resizesSucceeded.write(bw)
+	m.resizesFailed.write(bw)
+	m.phaseDuration.write(bw)
+	m.awsCalls.write(bw)
+	m.awsCallErrors.write(bw)
+	m.awsCallLatency.write(bw)
+	m.scrapeFailures.write(bw)
+
+	age := -1.0
+	if fetched := app.catalog.fetchedAt(); !fetched.IsZero() {
+		age = time.Since(fetched).Seconds()
+	}
+	fmt.Fprintf(bw, "# HELP resize_instance_type_cache_age_seconds Age of the cached instance type catalog, -1 if it has not been loaded.\n")
+	fmt.Fprintf(bw, "# TYPE resize_instance_type_cache_age_seconds gauge\n")
+	fmt.Fprintf(bw, "resize_instance_type_cache_age_seconds %s\n", formatFloat(age))
+
+	m.websockets.write(bw)
+	bw.Flush()
+}
@@ -0,0 +1,93 @@
+package resize
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram("test_seconds", "A test.", "phase", []float64{1, 5})
+	h.observe("stop", 0.5)
+	h.observe("stop", 3)
+	h.observe("stop", 10)
+
+	buf := new(bytes.Buffer)
+	h.write(buf)
+	expected := `# HELP test_seconds A test.
+# TYPE test_seconds histogram
+test_seconds_bucket{phase="stop",le="1"} 1
+test_seconds_bucket{phase="stop",le="5"} 2
+test_seconds_bucket{phase="stop",le="+Inf"} 3
+test_seconds_sum{phase="stop"} 13.5
+test_seconds_count{phase="stop"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestCounterEscaping(t *testing.T) {
+	c := newCounter("test_total", "A test.", "reason")
+	c.inc(`bad "quote"`)
+	buf := new(bytes.Buffer)
+	c.write(buf)
+	if !strings.Contains(buf.String(), `test_total{reason="bad \"quote\""} 1`) {
+		t.Errorf("label value not escaped:\n%s", buf.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.HTTPClient = http.DefaultClient
+	region := aws.Region{Name: "test-region", EC2Endpoint: srv.URL()}
+	ec2Cli := ec2.NewWithClient(aws.Auth{}, region, app.httpClient())
+	if _, err := ec2Cli.Instances(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ec2Cli.TerminateInstances([]string{"i-doesnotexist"}); err == nil {
+		t.Fatal("expected error terminating unknown instance")
+	}
+
+	rec := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bad response from /metrics: %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`resize_aws_calls_total{operation="DescribeInstances"} 1`,
+		`resize_aws_calls_total{operation="TerminateInstances"} 1`,
+		`resize_aws_call_errors_total{operation="TerminateInstances"} 1`,
+		`resize_aws_call_duration_seconds_count{operation="DescribeInstances"} 1`,
+		`resize_instance_type_cache_age_seconds -1`,
+		`resize_websocket_connections 0`,
+		`resize_resizes_started_total 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q", line)
+		}
+	}
+	if strings.Contains(body, `resize_aws_call_errors_total{operation="DescribeInstances"}`) {
+		t.Errorf("successful call counted as an error")
+	}
+}
@@ -0,0 +1,93 @@
+package resize
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// url returns the URL of a path in the App, such as "/login", taking the
+// base path into account.
+func (app *App) url(p string) string {
+	return app.BasePath + p
+}
+
+// stripBasePath returns a request for the path below the base path, which
+// the router matches against. ok is false if the request is not for a path
+// in the App.
+func (app *App) stripBasePath(r *http.Request) (req *http.Request, ok bool) {
+	p := strings.TrimPrefix(r.URL.Path, app.BasePath)
+	if p == r.URL.Path || (p != "" && p[0] != '/') {
+		return nil, false
+	}
+	req = new(http.Request)
+	*req = *r
+	req.URL = new(url.URL)
+	*req.URL = *r.URL
+	req.URL.Path = p
+	req.URL.RawPath = ""
+	return req, true
+}
+
+// serveMounted serves requests for the App mounted at its base path. Paths
+// are cleaned before the base path is removed, so the router never
+// redirects to a path outside the App.
+func (app *App) serveMounted(w http.ResponseWriter, r *http.Request) {
+	if p := cleanPath(r.URL.Path); p != r.URL.Path || p == app.BasePath {
+		to := *r.URL
+		to.Path = p
+		if p == app.BasePath {
+			to.Path += "/"
+		}
+		http.Redirect(w, r, to.String(), http.StatusMovedPermanently)
+		return
+	}
+	req, ok := app.stripBasePath(r)
+	if !ok {
+		app.render404(w, r)
+		return
+	}
+	app.router.ServeHTTP(w, req)
+}
+
+// cleanPath returns the canonical form of p, keeping a trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// IsHTTPS reports if the request reached the App over HTTPS, directly or
+// through a trusted proxy which set the URL scheme.
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
+
+// session returns the App's session for the request. Its cookie is scoped
+// to the base path, only sent over HTTPS if the request was, and kept no
+// longer than the session timeout.
+func (app *App) session(r *http.Request) *sessions.Session {
+	// ignore error from decoding an existing session
+	session, _ := app.store.Get(r, "yhat-resize")
+	options := *app.store.Options
+	if app.BasePath != "" {
+		options.Path = app.BasePath + "/"
+	}
+	options.MaxAge = int(app.sessionTimeout() / time.Second)
+	options.HttpOnly = true
+	options.Secure = options.Secure || IsHTTPS(r)
+	session.Options = &options
+	return session
+}
@@ -0,0 +1,96 @@
+package resize
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.BasePath = "/tools/resize"
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/tools/resize/about", http.StatusOK, ""},
+		{"/tools/resize/js/global.js", http.StatusOK, ""},
+		{"/tools/resize", http.StatusMovedPermanently, "/tools/resize/"},
+		{"/tools/resize/../resize/about?x=1", http.StatusMovedPermanently, "/tools/resize/about?x=1"},
+		{"/tools/resize/", http.StatusTemporaryRedirect, "/tools/resize/login"},
+		{"/tools/resizer/about", http.StatusNotFound, ""},
+		{"/about", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// keep the unclean path, as a raw HTTP request would
+		r.URL.Path = strings.SplitN(test.path, "?", 2)[0]
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("%s: expected redirect to %q, got %q", test.path, test.location, loc)
+		}
+	}
+
+	r, err := http.NewRequest("GET", "/tools/resize/about", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `<base href="/tools/resize/">`) {
+		t.Error("expected pages to link relative to the base path")
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Path != "/tools/resize/" {
+			t.Errorf("expected cookie %s scoped to the base path, got path %q", cookie.Name, cookie.Path)
+		}
+	}
+}
+
+func TestSessionSecure(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		scheme string
+		tls    bool
+		secure bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{"https", false, true},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.URL.Scheme = test.scheme
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if opts := app.session(r).Options; opts.Secure != test.secure || !opts.HttpOnly || opts.Path != "/" {
+			t.Errorf("scheme %q, tls %t: unexpected cookie options %+v", test.scheme, test.tls, opts)
+		}
+	}
+}
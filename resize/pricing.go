@@ -0,0 +1,432 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// DefaultPricingURL is the AWS Price List bulk offer file for EC2.
+const DefaultPricingURL = "https://pricing.us-east-1.amazonaws.com/offers/v1.0/aws/AmazonEC2/current/index.json"
+
+// hoursPerMonth is used to estimate monthly costs from hourly prices.
+const hoursPerMonth = 730
+
+// regionLocations maps region names to the location names used by offer
+// files which predate the regionCode attribute.
+var regionLocations = map[string][]string{
+	"ap-northeast-1": {"Asia Pacific (Tokyo)"},
+	"ap-southeast-1": {"Asia Pacific (Singapore)"},
+	"ap-southeast-2": {"Asia Pacific (Sydney)"},
+	"eu-central-1":   {"EU (Frankfurt)", "Europe (Frankfurt)"},
+	"eu-west-1":      {"EU (Ireland)", "Europe (Ireland)"},
+	"sa-east-1":      {"South America (Sao Paulo)"},
+	"us-east-1":      {"US East (N. Virginia)"},
+	"us-gov-west-1":  {"AWS GovCloud (US)", "AWS GovCloud (US-West)"},
+	"us-west-1":      {"US West (N. California)"},
+	"us-west-2":      {"US West (Oregon)"},
+}
+
+// PriceKey identifies the on-demand price of an instance type.
+type PriceKey struct {
+	Region       string
+	InstanceType string
+	OS           string // as used by offer files, "Linux", "Windows", ...
+	Tenancy      string // as used by offer files, "Shared", "Dedicated", ...
+}
+
+// Cost is the on-demand cost of running an instance, in USD.
+type Cost struct {
+	Hourly  float64
+	Monthly float64
+}
+
+func newCost(hourly float64) *Cost {
+	return &Cost{Hourly: hourly, Monthly: hourly * hoursPerMonth}
+}
+
+// Pricing holds on-demand EC2 prices parsed from an offer file.
+type Pricing struct {
+	Version         string
+	PublicationDate string
+
+	prices map[PriceKey]float64
+}
+
+// offerProduct and offerTerm are the subset of the Price List bulk JSON
+// format used to build a Pricing.
+type offerProduct struct {
+	ProductFamily string            `json:"productFamily"`
+	Attributes    map[string]string `json:"attributes"`
+}
+
+type offerTerm struct {
+	PriceDimensions map[string]struct {
+		Unit         string            `json:"unit"`
+		PricePerUnit map[string]string `json:"pricePerUnit"`
+	} `json:"priceDimensions"`
+}
+
+// ParsePricing reads an EC2 offer file in the AWS Price List bulk JSON
+// format. Offer files run to gigabytes, so they are decoded one product and
+// term at a time and only on-demand prices of plain instances are kept.
+func ParsePricing(r io.Reader) (*Pricing, error) {
+	locations := make(map[string]string)
+	for region, names := range regionLocations {
+		for _, name := range names {
+			locations[name] = region
+		}
+	}
+
+	p := &Pricing{prices: make(map[PriceKey]float64)}
+	keys := make(map[string]PriceKey) // by SKU
+	hourly := make(map[string]float64)
+	productsRead := false
+	d := json.NewDecoder(r)
+	err := decodeObject(d, func(name string) error {
+		switch name {
+		case "version":
+			return d.Decode(&p.Version)
+		case "publicationDate":
+			return d.Decode(&p.PublicationDate)
+		case "products":
+			productsRead = true
+			return decodeObject(d, func(sku string) error {
+				var product offerProduct
+				if err := d.Decode(&product); err != nil {
+					return err
+				}
+				if key, ok := product.key(locations); ok {
+					keys[sku] = key
+				}
+				return nil
+			})
+		case "terms":
+			return decodeObject(d, func(name string) error {
+				if name != "OnDemand" {
+					return skipValue(d)
+				}
+				return decodeObject(d, func(sku string) error {
+					if _, ok := keys[sku]; productsRead && !ok {
+						return skipValue(d)
+					}
+					var terms map[string]offerTerm
+					if err := d.Decode(&terms); err != nil {
+						return err
+					}
+					if price, ok := onDemandHourly(terms); ok {
+						hourly[sku] = price
+					}
+					return nil
+				})
+			})
+		}
+		return skipValue(d)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decoding offer file: %v", err)
+	}
+	for sku, key := range keys {
+		if price, ok := hourly[sku]; ok {
+			p.prices[key] = price
+		}
+	}
+	return p, nil
+}
+
+// key returns the price key of a product, if it is a plain instance in a
+// known region.
+func (product offerProduct) key(locations map[string]string) (PriceKey, bool) {
+	attr := product.Attributes
+	if product.ProductFamily != "Compute Instance" || attr["instanceType"] == "" {
+		return PriceKey{}, false
+	}
+	// only consider plain instances, not those with preinstalled
+	// software, BYOL licenses or capacity reservations
+	if sw := attr["preInstalledSw"]; sw != "" && sw != "NA" {
+		return PriceKey{}, false
+	}
+	if attr["licenseModel"] == "Bring your own license" {
+		return PriceKey{}, false
+	}
+	if cs := attr["capacitystatus"]; cs != "" && cs != "Used" {
+		return PriceKey{}, false
+	}
+	region := attr["regionCode"]
+	if region == "" {
+		region = locations[attr["location"]]
+	}
+	if region == "" {
+		return PriceKey{}, false
+	}
+	return PriceKey{
+		Region:       region,
+		InstanceType: attr["instanceType"],
+		OS:           attr["operatingSystem"],
+		Tenancy:      attr["tenancy"],
+	}, true
+}
+
+// onDemandHourly returns the USD hourly price of a product's on-demand
+// terms.
+func onDemandHourly(terms map[string]offerTerm) (float64, bool) {
+	for _, term := range terms {
+		for _, dim := range term.PriceDimensions {
+			if !strings.HasPrefix(dim.Unit, "Hr") {
+				continue
+			}
+			usd, ok := dim.PricePerUnit["USD"]
+			if !ok {
+				continue
+			}
+			price, err := strconv.ParseFloat(usd, 64)
+			if err != nil {
+				continue
+			}
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+// decodeObject reads a JSON object, calling f with the name of each member
+// once d is positioned at its value. f must read the value.
+func decodeObject(d *json.Decoder, f func(name string) error) error {
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("expected an object, got %v", tok)
+	}
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("expected a member name, got %v", tok)
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	_, err = d.Token()
+	return err
+}
+
+// skipValue reads past the next JSON value a token at a time, so large
+// values are never held in memory.
+func skipValue(d *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// LoadPricing reads an offer file from disk.
+func LoadPricing(path string) (*Pricing, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParsePricing(file)
+}
+
+// Cost returns the on-demand cost for a price key.
+func (p *Pricing) Cost(key PriceKey) (*Cost, bool) {
+	hourly, ok := p.prices[key]
+	if !ok {
+		return nil, false
+	}
+	return newCost(hourly), true
+}
+
+// pricingTimeout limits how long downloading the offer file may take.
+var pricingTimeout = time.Hour
+
+// pricingStore holds the App's pricing data, safe for concurrent use.
+type pricingStore struct {
+	mu         sync.Mutex
+	pricing    *Pricing
+	refreshing bool
+}
+
+// pricing returns the App's pricing data, or nil if none has been loaded.
+func (app *App) pricing() *Pricing {
+	s := app.prices
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pricing
+}
+
+func (app *App) setPricing(pricing *Pricing) {
+	s := app.prices
+	s.mu.Lock()
+	s.pricing = pricing
+	s.mu.Unlock()
+}
+
+// LoadPricingFile loads the App's pricing data from PricingFile. It is
+// meant to be called on startup, as parsing an offer file takes a while.
+func (app *App) LoadPricingFile() error {
+	if app.PricingFile == "" {
+		return fmt.Errorf("no pricing file configured")
+	}
+	pricing, err := LoadPricing(app.PricingFile)
+	if err != nil {
+		return err
+	}
+	app.setPricing(pricing)
+	return nil
+}
+
+// RefreshPricing downloads the offer file at PricingURL to PricingFile and
+// reloads the App's pricing data from it. Only one refresh runs at a time.
+func (app *App) RefreshPricing() error {
+	if app.PricingFile == "" {
+		return fmt.Errorf("no pricing file configured")
+	}
+	s := app.prices
+	s.mu.Lock()
+	if s.refreshing {
+		s.mu.Unlock()
+		return fmt.Errorf("pricing data is already being refreshed")
+	}
+	s.refreshing = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.refreshing = false
+		s.mu.Unlock()
+	}()
+
+	url := app.PricingURL
+	if url == "" {
+		url = DefaultPricingURL
+	}
+	client := *app.httpClient()
+	client.Timeout = pricingTimeout
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad response from AWS: %s", resp.Status)
+	}
+
+	// write to a temporary file so a failed download doesn't clobber the
+	// existing data
+	file, err := ioutil.TempFile(filepath.Dir(app.PricingFile), filepath.Base(app.PricingFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	_, err = io.Copy(file, resp.Body)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	pricing, err := LoadPricing(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, app.PricingFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	app.setPricing(pricing)
+	return nil
+}
+
+// priceKey builds the price key for an instance. The operating system is
+// determined from the instance's image.
+func priceKey(ec2Cli *ec2.EC2, instance ec2.Instance) PriceKey {
+	key := PriceKey{
+		Region:       ec2Cli.Region.Name,
+		InstanceType: instance.InstanceType,
+		OS:           "Linux",
+		Tenancy:      "Shared",
+	}
+	switch instance.Tenancy {
+	case "dedicated":
+		key.Tenancy = "Dedicated"
+	case "host":
+		key.Tenancy = "Host"
+	}
+	resp, err := ec2Cli.Images([]string{instance.ImageId}, nil)
+	if err == nil && len(resp.Images) == 1 && resp.Images[0].Platform == "windows" {
+		key.OS = "Windows"
+	}
+	return key
+}
+
+// costs returns the cost of the instance and of each of the instance types
+// it could be resized to.
+func (app *App) costs(ec2Cli *ec2.EC2, instance ec2.Instance, types []InstanceType) (*Cost, map[string]*Cost, bool) {
+	pricing := app.pricing()
+	if pricing == nil {
+		return nil, nil, false
+	}
+	key := priceKey(ec2Cli, instance)
+	current, ok := pricing.Cost(key)
+	if !ok {
+		return nil, nil, false
+	}
+	proposed := make(map[string]*Cost)
+	for _, t := range types {
+		key.InstanceType = t.Name
+		if cost, ok := pricing.Cost(key); ok {
+			proposed[t.Name] = cost
+		}
+	}
+	return current, proposed, true
+}
+
+// Path: /pricing/refresh
+func (app *App) handleRefreshPricing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if app.PricingFile == "" {
+		http.Error(w, "No pricing file configured", http.StatusBadRequest)
+		return
+	}
+	// offer files are large, download in the background
+	go func() {
+		if err := app.RefreshPricing(); err != nil {
+			app.Logf("could not refresh pricing data: %v", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,180 @@
+package resize
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// offer is a trimmed down EC2 offer file in the Price List bulk JSON format.
+const offer = `{
+  "formatVersion": "v1.0",
+  "offerCode": "AmazonEC2",
+  "version": "20151001000000",
+  "publicationDate": "2015-10-01T00:00:00Z",
+  "products": {
+    "SKU1": {
+      "sku": "SKU1",
+      "productFamily": "Compute Instance",
+      "attributes": {
+        "location": "US East (N. Virginia)",
+        "instanceType": "m3.large",
+        "tenancy": "Shared",
+        "operatingSystem": "Linux",
+        "licenseModel": "No License required",
+        "preInstalledSw": "NA"
+      }
+    },
+    "SKU2": {
+      "sku": "SKU2",
+      "productFamily": "Compute Instance",
+      "attributes": {
+        "regionCode": "us-east-1",
+        "location": "US East (N. Virginia)",
+        "instanceType": "m3.xlarge",
+        "tenancy": "Shared",
+        "operatingSystem": "Linux",
+        "licenseModel": "No License required",
+        "preInstalledSw": "NA",
+        "capacitystatus": "Used"
+      }
+    },
+    "SKU3": {
+      "sku": "SKU3",
+      "productFamily": "Compute Instance",
+      "attributes": {
+        "location": "US East (N. Virginia)",
+        "instanceType": "m3.large",
+        "tenancy": "Shared",
+        "operatingSystem": "Linux",
+        "preInstalledSw": "SQL Web"
+      }
+    },
+    "SKU4": {
+      "sku": "SKU4",
+      "productFamily": "Storage",
+      "attributes": {
+        "location": "US East (N. Virginia)",
+        "volumeType": "Magnetic"
+      }
+    }
+  },
+  "terms": {
+    "Reserved": {
+      "SKU1": {"SKU1.4NA7Y494T4": {"priceDimensions": {"a": {"unit": "Hrs", "pricePerUnit": {"USD": "0.05"}}}}}
+    },
+    "OnDemand": {
+      "SKU1": {
+        "SKU1.JRTCKXETXF": {
+          "priceDimensions": {
+            "SKU1.JRTCKXETXF.6YS6EN2CT7": {
+              "unit": "Hrs",
+              "pricePerUnit": {"USD": "0.1330000000"}
+            }
+          }
+        }
+      },
+      "SKU2": {
+        "SKU2.JRTCKXETXF": {
+          "priceDimensions": {
+            "SKU2.JRTCKXETXF.6YS6EN2CT7": {
+              "unit": "Hrs",
+              "pricePerUnit": {"USD": "0.2660000000"}
+            }
+          }
+        }
+      },
+      "SKU3": {
+        "SKU3.JRTCKXETXF": {
+          "priceDimensions": {
+            "SKU3.JRTCKXETXF.6YS6EN2CT7": {
+              "unit": "Hrs",
+              "pricePerUnit": {"USD": "0.5000000000"}
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestParsePricing(t *testing.T) {
+	p, err := ParsePricing(strings.NewReader(offer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != "20151001000000" {
+		t.Errorf("unexpected version %s", p.Version)
+	}
+	tests := []struct {
+		key    PriceKey
+		hourly float64
+		ok     bool
+	}{
+		{PriceKey{"us-east-1", "m3.large", "Linux", "Shared"}, 0.133, true},
+		{PriceKey{"us-east-1", "m3.xlarge", "Linux", "Shared"}, 0.266, true},
+		{PriceKey{"us-east-1", "m3.large", "Windows", "Shared"}, 0, false},
+		{PriceKey{"us-west-2", "m3.large", "Linux", "Shared"}, 0, false},
+	}
+	for _, test := range tests {
+		cost, ok := p.Cost(test.key)
+		if ok != test.ok {
+			t.Errorf("%+v: expected ok=%t", test.key, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if cost.Hourly != test.hourly {
+			t.Errorf("%+v: expected hourly cost %g got %g", test.key, test.hourly, cost.Hourly)
+		}
+		if cost.Monthly != test.hourly*hoursPerMonth {
+			t.Errorf("%+v: expected monthly cost %g got %g", test.key, test.hourly*hoursPerMonth, cost.Monthly)
+		}
+	}
+}
+
+func TestRefreshPricing(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte(offer))
+	}))
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "pricing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.HTTPClient = &http.Client{}
+	app.PricingFile = filepath.Join(dir, "offer.json")
+	app.PricingURL = s.URL
+
+	errs := make(chan error)
+	go func() { errs <- app.RefreshPricing() }()
+	<-started
+	if err := app.RefreshPricing(); err == nil {
+		t.Error("expected only one refresh to run at a time")
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if app.pricing() == nil {
+		t.Error("expected the pricing data to be loaded")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "offer.json" {
+		t.Errorf("expected only the offer file to be left, got %v", files)
+	}
+}
@@ -0,0 +1,203 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/vaughan0/go-ini"
+)
+
+// Provider is an EC2-compatible private cloud, such as Eucalyptus or the
+// OpenStack EC2 API, which users can log into instead of AWS. A provider
+// is offered as a single region with the provider's name.
+type Provider struct {
+	Name     string
+	Endpoint string // URL of the EC2 query API
+
+	// SignatureMethod is the signature version 2 method used to sign
+	// requests, "HmacSHA256" or "HmacSHA1". If empty, HmacSHA256 is used.
+	SignatureMethod string
+
+	// APIVersion overrides the EC2 API version sent with each request,
+	// for endpoints which only implement older versions.
+	APIVersion string
+}
+
+// Region returns the region used to address the provider.
+func (p Provider) Region() aws.Region {
+	return aws.Region{Name: p.Name, EC2Endpoint: p.Endpoint}
+}
+
+// LoadProviders reads provider profiles from an INI file, one section per
+// provider, for example:
+//
+//	[eucalyptus]
+//	ec2_endpoint = https://cloud.example.com:8773/services/compute
+//	signature_method = HmacSHA1
+//	api_version = 2013-10-15
+func LoadProviders(filename string) ([]Provider, error) {
+	file, err := ini.LoadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var providers []Provider
+	for name, section := range file {
+		if name == "" {
+			continue
+		}
+		p := Provider{
+			Name:            name,
+			Endpoint:        section["ec2_endpoint"],
+			SignatureMethod: section["signature_method"],
+			APIVersion:      section["api_version"],
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		providers = append(providers, p)
+	}
+	sort.Sort(byProviderName(providers))
+	return providers, nil
+}
+
+func (p Provider) validate() error {
+	if _, ok := aws.Regions[p.Name]; ok {
+		return fmt.Errorf("provider %s has the name of an AWS region", p.Name)
+	}
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("provider %s has an invalid ec2_endpoint %q", p.Name, p.Endpoint)
+	}
+	switch p.SignatureMethod {
+	case "", "HmacSHA256", "HmacSHA1":
+	default:
+		return fmt.Errorf("provider %s has an unknown signature_method %q", p.Name, p.SignatureMethod)
+	}
+	return nil
+}
+
+type byProviderName []Provider
+
+func (s byProviderName) Len() int           { return len(s) }
+func (s byProviderName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byProviderName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// provider looks up the provider addressed by a region name.
+func (app *App) provider(name string) (Provider, bool) {
+	for _, p := range app.Providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
+
+// regionClient returns a client for region, signing requests as the
+// region's provider requires.
+func (app *App) regionClient(auth aws.Auth, region aws.Region, client *http.Client) *ec2.EC2 {
+	if p, ok := app.provider(region.Name); ok && (p.SignatureMethod != "" || p.APIVersion != "") {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		c := *client
+		c.Transport = &signingTransport{base: base, auth: auth, provider: p}
+		client = &c
+	}
+	return ec2.NewWithClient(auth, region, client)
+}
+
+// signingTransport re-signs requests made by goamz, which always uses
+// HmacSHA256 and its own API version, with a provider's settings.
+type signingTransport struct {
+	base     http.RoundTripper
+	auth     aws.Auth
+	provider Provider
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	params := make(map[string]string)
+	for k, v := range req.URL.Query() {
+		if k != "Signature" {
+			params[k] = v[0]
+		}
+	}
+	if t.provider.APIVersion != "" {
+		params["Version"] = t.provider.APIVersion
+	}
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	signV2(t.auth, t.provider.SignatureMethod, req.Method, path, params, req.URL.Host)
+
+	query := make(url.Values)
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	u := *req.URL
+	u.RawQuery = query.Encode()
+	r := new(http.Request)
+	*r = *req
+	r.URL = &u
+	return t.base.RoundTrip(r)
+}
+
+// notImplemented reports if an error means the endpoint does not implement
+// an action, as EC2-compatible clouds often do not.
+func notImplemented(err error) bool {
+	ec2Err, ok := err.(*ec2.Error)
+	if !ok {
+		return false
+	}
+	if ec2Err.StatusCode == http.StatusNotImplemented {
+		return true
+	}
+	switch ec2Err.Code {
+	case "NotImplemented", "InvalidAction", "Unsupported", "UnsupportedOperation":
+		return true
+	}
+	return false
+}
+
+// Features which are hidden in regions that do not implement them.
+const featureAddresses = "addresses"
+
+// featureSet records the features each region has been found not to
+// implement.
+type featureSet struct {
+	mu          sync.Mutex
+	unsupported map[string]map[string]bool
+}
+
+func newFeatureSet() *featureSet {
+	return &featureSet{unsupported: make(map[string]map[string]bool)}
+}
+
+// disable marks a feature as not implemented by a region.
+func (f *featureSet) disable(region, feature string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unsupported[region] == nil {
+		f.unsupported[region] = make(map[string]bool)
+	}
+	f.unsupported[region][feature] = true
+}
+
+// supported reports if a region may implement a feature.
+func (f *featureSet) supported(region, feature string) bool {
+	if f == nil {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.unsupported[region][feature]
+}
@@ -0,0 +1,150 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestLoadProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "providers.ini")
+
+	tests := []struct {
+		config string
+		ok     bool
+	}{
+		{"[euca]\nec2_endpoint = https://cloud.example.com:8773/services/compute\nsignature_method = HmacSHA1\n", true},
+		{"[euca]\nec2_endpoint = cloud.example.com\n", false},
+		{"[euca]\nec2_endpoint = https://cloud.example.com\nsignature_method = MD5\n", false},
+		{"[us-east-1]\nec2_endpoint = https://cloud.example.com\n", false},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filename, []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		providers, err := LoadProviders(filename)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%q: expected ok=%t, got error %v", test.config, test.ok, err)
+			continue
+		}
+		if test.ok && (len(providers) != 1 || providers[0].Name != "euca" || providers[0].SignatureMethod != "HmacSHA1") {
+			t.Errorf("%q: unexpected providers %v", test.config, providers)
+		}
+	}
+}
+
+// providerServer is a stand-in for an EC2-compatible endpoint which checks
+// requests are signed with HmacSHA1 and use an older API version.
+func providerServer(t *testing.T, auth aws.Auth) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := make(map[string]string)
+		for k, v := range r.URL.Query() {
+			params[k] = v[0]
+		}
+		got := params["Signature"]
+		delete(params, "Signature")
+		signV2(auth, "HmacSHA1", r.Method, r.URL.Path, params, r.Host)
+		switch {
+		case params["SignatureMethod"] != "HmacSHA1" || got != params["Signature"]:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>AuthFailure</Code><Message>bad signature</Message></Error></Errors></Response>`)
+		case params["Version"] != "2013-10-15":
+			t.Errorf("expected API version 2013-10-15, got %s", params["Version"])
+			w.WriteHeader(http.StatusBadRequest)
+		case params["Action"] == "DescribeAddresses":
+			w.WriteHeader(http.StatusNotImplemented)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>NotImplemented</Code><Message>not implemented</Message></Error></Errors></Response>`)
+		default:
+			fmt.Fprint(w, `<DescribeInstancesResponse><requestId>r</requestId><reservationSet></reservationSet></DescribeInstancesResponse>`)
+		}
+	}))
+}
+
+func TestProviderLogin(t *testing.T) {
+	auth := aws.Auth{AccessKey: "AKID", SecretKey: "secret"}
+	s := providerServer(t, auth)
+	defer s.Close()
+
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.HTTPClient = &http.Client{}
+	app.Providers = []Provider{{Name: "private", Endpoint: s.URL, SignatureMethod: "HmacSHA1", APIVersion: "2013-10-15"}}
+
+	r, _ := http.NewRequest("POST", "/login", nil)
+	w := httptest.NewRecorder()
+	if err := app.login(w, r, "private", auth.AccessKey, auth.SecretKey); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		t.Fatal("no credentials found after logging into a provider")
+	}
+	if ec2Cli.Region.Name != "private" {
+		t.Errorf("expected the provider's region, got %s", ec2Cli.Region.Name)
+	}
+	if regions := app.accountRegions(ec2Cli); len(regions) != 1 || regions[0].Name != "private" {
+		t.Errorf("expected only the provider's region to be offered, got %v", regions)
+	}
+	if _, err := ec2Cli.Instances(nil, nil); err != nil {
+		t.Errorf("expected requests to be signed for the provider: %v", err)
+	}
+	if _, err := openIps(ec2Cli); !notImplemented(err) {
+		t.Errorf("expected a not implemented error listing addresses, got %v", err)
+	}
+
+	if err := app.login(httptest.NewRecorder(), r, "private", auth.AccessKey, "wrong"); err == nil {
+		t.Errorf("expected login with the wrong secret key to fail")
+	}
+	if err := app.login(httptest.NewRecorder(), r, "missing", auth.AccessKey, auth.SecretKey); err == nil {
+		t.Errorf("expected login to an unknown provider to fail")
+	}
+}
+
+func TestNotImplemented(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&ec2.Error{Code: "NotImplemented"}, true},
+		{&ec2.Error{Code: "InvalidAction"}, true},
+		{&ec2.Error{StatusCode: http.StatusNotImplemented}, true},
+		{&ec2.Error{Code: "AuthFailure"}, false},
+		{fmt.Errorf("NotImplemented"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := notImplemented(test.err); got != test.want {
+			t.Errorf("%v: expected %t, got %t", test.err, test.want, got)
+		}
+	}
+}
+
+func TestFeatureSet(t *testing.T) {
+	f := newFeatureSet()
+	if !f.supported("private", featureAddresses) {
+		t.Errorf("expected features to be supported until disabled")
+	}
+	f.disable("private", featureAddresses)
+	if f.supported("private", featureAddresses) {
+		t.Errorf("expected addresses to be unsupported after being disabled")
+	}
+	if !f.supported("us-east-1", featureAddresses) {
+		t.Errorf("expected disabling a feature to only affect one region")
+	}
+}
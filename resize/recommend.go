@@ -0,0 +1,465 @@
+package resize
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const (
+	// utilizationWindow is how far back utilization is considered.
+	utilizationWindow = 14 * 24 * time.Hour
+	// utilizationPeriod is the granularity of CloudWatch statistics.
+	utilizationPeriod = time.Hour
+
+	// Recommendations leave headroom by targeting these utilizations at
+	// the 95th percentile.
+	targetCPU    = 70.0
+	targetMemory = 80.0
+	// targetNetwork is the share of an instance type's bandwidth the peak
+	// network throughput may use.
+	targetNetwork = 70.0
+
+	// memoryNamespace and memoryMetric are the custom metric reported by
+	// the CloudWatch monitoring scripts, since EC2 doesn't report memory.
+	memoryNamespace = "System/Linux"
+	memoryMetric    = "MemoryUtilization"
+)
+
+// Utilization is a time series of an instance's resource usage.
+type Utilization struct {
+	CPU     []float64 // percent
+	Memory  []float64 // percent, empty if not reported
+	Network []float64 // bytes per second, in and out combined
+}
+
+// UtilizationSummary summarizes a Utilization.
+type UtilizationSummary struct {
+	Samples     int
+	CPUP95      float64
+	CPUMax      float64
+	MemoryP95   float64
+	HasMemory   bool
+	NetworkPeak float64 // bytes per second
+}
+
+// percentile returns the p-th percentile of values using the nearest rank
+// method.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func maximum(values []float64) float64 {
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Summary summarizes the utilization.
+func (u Utilization) Summary() UtilizationSummary {
+	return UtilizationSummary{
+		Samples:     len(u.CPU),
+		CPUP95:      percentile(u.CPU, 95),
+		CPUMax:      maximum(u.CPU),
+		MemoryP95:   percentile(u.Memory, 95),
+		HasMemory:   len(u.Memory) > 0,
+		NetworkPeak: maximum(u.Network),
+	}
+}
+
+// ParseUtilizationCSV reads utilization from a CSV file with a header row.
+// A "cpu" column, in percent, is required. Optional "memory", in percent,
+// and "network_in" and "network_out", in bytes per second, columns are also
+// read. Other columns, such as a timestamp, are ignored.
+func ParseUtilizationCSV(r io.Reader) (Utilization, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return Utilization{}, fmt.Errorf("reading CSV header: %v", err)
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["cpu"]; !ok {
+		return Utilization{}, fmt.Errorf("CSV has no 'cpu' column")
+	}
+	value := func(record []string, name string) (float64, bool, error) {
+		i, ok := cols[name]
+		if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			return 0, false, nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("expected number for %s, got '%s'", name, record[i])
+		}
+		return v, true, nil
+	}
+
+	var u Utilization
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Utilization{}, err
+		}
+		cpu, ok, err := value(record, "cpu")
+		if err != nil {
+			return Utilization{}, fmt.Errorf("line %d: %v", line, err)
+		}
+		if !ok {
+			continue
+		}
+		u.CPU = append(u.CPU, cpu)
+		if mem, ok, err := value(record, "memory"); err != nil {
+			return Utilization{}, fmt.Errorf("line %d: %v", line, err)
+		} else if ok {
+			u.Memory = append(u.Memory, mem)
+		}
+		in, okIn, err := value(record, "network_in")
+		if err != nil {
+			return Utilization{}, fmt.Errorf("line %d: %v", line, err)
+		}
+		out, okOut, err := value(record, "network_out")
+		if err != nil {
+			return Utilization{}, fmt.Errorf("line %d: %v", line, err)
+		}
+		if okIn || okOut {
+			u.Network = append(u.Network, in+out)
+		}
+	}
+	if len(u.CPU) == 0 {
+		return Utilization{}, fmt.Errorf("CSV has no samples")
+	}
+	return u, nil
+}
+
+// FetchUtilization reads an instance's utilization over the last two weeks
+// from CloudWatch. Memory utilization is only available if the instance
+// reports it with the CloudWatch monitoring scripts.
+func FetchUtilization(src MetricSource, instanceId string, now time.Time) (Utilization, error) {
+	req := GetMetricStatistics{
+		Namespace:  "AWS/EC2",
+		Dimensions: map[string]string{"InstanceId": instanceId},
+		StartTime:  now.Add(-utilizationWindow),
+		EndTime:    now,
+		Period:     utilizationPeriod,
+	}
+	get := func(namespace, metric, stat string) ([]Datapoint, error) {
+		r := req
+		r.Namespace, r.MetricName, r.Statistics = namespace, metric, []string{stat}
+		return src.GetMetricStatistics(&r)
+	}
+
+	var u Utilization
+	cpu, err := get("AWS/EC2", "CPUUtilization", "Average")
+	if err != nil {
+		return Utilization{}, fmt.Errorf("getting CPU utilization: %v", err)
+	}
+	for _, dp := range cpu {
+		u.CPU = append(u.CPU, dp.Average)
+	}
+
+	// memory is optional, ignore errors
+	if mem, err := get(memoryNamespace, memoryMetric, "Average"); err == nil {
+		for _, dp := range mem {
+			u.Memory = append(u.Memory, dp.Average)
+		}
+	}
+
+	in, err := get("AWS/EC2", "NetworkIn", "Sum")
+	if err != nil {
+		return Utilization{}, fmt.Errorf("getting network utilization: %v", err)
+	}
+	out, err := get("AWS/EC2", "NetworkOut", "Sum")
+	if err != nil {
+		return Utilization{}, fmt.Errorf("getting network utilization: %v", err)
+	}
+	byTime := make(map[time.Time]float64)
+	for _, dp := range append(in, out...) {
+		byTime[dp.Timestamp] += dp.Sum
+	}
+	for _, sum := range byTime {
+		u.Network = append(u.Network, sum/utilizationPeriod.Seconds())
+	}
+	return u, nil
+}
+
+// Recommendation suggests an instance type based on utilization.
+type Recommendation struct {
+	InstanceId  string
+	CurrentType string
+	Summary     UtilizationSummary
+
+	// Action is one of "downsize", "upsize", "keep" or "unknown".
+	Action        string
+	SuggestedType string
+	Reason        string
+
+	// Projected 95th percentile utilization, and the remaining headroom,
+	// on the suggested type.
+	ProjectedCPU    float64
+	ProjectedMemory float64
+	CPUHeadroom     float64
+	MemoryHeadroom  float64
+
+	// CostDelta is the change in cost of the suggested type, nil if
+	// pricing is not available.
+	CostDelta *Cost
+}
+
+// Recommend compares utilization on the current instance type against the
+// catalog and suggests the smallest type which keeps utilization under the
+// target. If hourly returns prices the cheapest such type is suggested.
+func Recommend(instanceId, currentType string, u Utilization, types []InstanceType, hourly func(string) (float64, bool)) Recommendation {
+	rec := Recommendation{
+		InstanceId:  instanceId,
+		CurrentType: currentType,
+		Summary:     u.Summary(),
+		Action:      "unknown",
+	}
+	if rec.Summary.Samples == 0 {
+		rec.Reason = "no utilization data"
+		return rec
+	}
+	var current InstanceType
+	found := false
+	for _, t := range types {
+		if t.Name == currentType {
+			current, found = t, true
+			break
+		}
+	}
+	if !found {
+		rec.Reason = "current instance type is not in the catalog"
+		return rec
+	}
+
+	// the resources used at the 95th percentile
+	cpus := float64(current.CPUs) * rec.Summary.CPUP95 / 100
+	mem := current.Memory * rec.Summary.MemoryP95 / 100
+
+	fits := func(t InstanceType) bool {
+		if float64(t.CPUs)*targetCPU/100 < cpus {
+			return false
+		}
+		if rec.Summary.HasMemory && t.Memory*targetMemory/100 < mem {
+			return false
+		}
+		// never suggest less memory than is used when it's unknown
+		if !rec.Summary.HasMemory && t.Memory < current.Memory {
+			return false
+		}
+		if bw, ok := t.bandwidth(); ok && bw*targetNetwork/100 < rec.Summary.NetworkPeak {
+			return false
+		}
+		return true
+	}
+	size := func(t InstanceType) float64 {
+		if price, ok := hourly(t.Name); ok {
+			return price
+		}
+		// without prices prefer fewer CPUs, then less memory
+		return float64(t.CPUs)*1e6 + t.Memory
+	}
+
+	var best *InstanceType
+	for i := range types {
+		t := types[i]
+		if !fits(t) {
+			continue
+		}
+		if best == nil || size(t) < size(*best) || (size(t) == size(*best) && t.Name == currentType) {
+			best = &types[i]
+		}
+	}
+	if best == nil {
+		rec.Reason = "no instance type in the catalog is large enough"
+		return rec
+	}
+
+	rec.SuggestedType = best.Name
+	rec.ProjectedCPU = cpus / float64(best.CPUs) * 100
+	rec.CPUHeadroom = 100 - rec.ProjectedCPU
+	if rec.Summary.HasMemory {
+		rec.ProjectedMemory = mem / best.Memory * 100
+		rec.MemoryHeadroom = 100 - rec.ProjectedMemory
+	}
+	switch {
+	case best.Name == currentType:
+		rec.Action = "keep"
+		rec.Reason = "current instance type fits utilization"
+	case !fits(current):
+		rec.Action = "upsize"
+		rec.Reason = "utilization exceeds target on current instance type"
+	default:
+		rec.Action = "downsize"
+		rec.Reason = "a smaller instance type fits utilization"
+	}
+	if from, ok := hourly(currentType); ok {
+		if to, ok := hourly(best.Name); ok {
+			rec.CostDelta = newCost(to - from)
+		}
+	}
+	return rec
+}
+
+// networkSpeeds approximates the bandwidth, in bits per second, of the
+// network performance ratings the catalog gives older instance types.
+var networkSpeeds = map[string]float64{
+	"very low":        50e6,
+	"low":             100e6,
+	"low to moderate": 300e6,
+	"moderate":        500e6,
+	"high":            1e9,
+}
+
+// bandwidth returns the approximate network bandwidth of an instance type in
+// bytes per second, or false if its network performance is not known.
+func (t InstanceType) bandwidth() (float64, bool) {
+	spec := strings.ToLower(strings.TrimSpace(t.NetworkSpec))
+	if bits, ok := networkSpeeds[spec]; ok {
+		return bits / 8, true
+	}
+	var gbits float64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(spec, "up to "), "%g gigabit", &gbits); err == nil && gbits > 0 {
+		return gbits * 1e9 / 8, true
+	}
+	return 0, false
+}
+
+// family returns the family of an instance type, such as "m3" or "c6gn".
+func family(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// arm reports if an instance type has Graviton processors, which families
+// mark with a "g" after their generation, as in m6g or c7gn.
+func arm(name string) bool {
+	f := family(name)
+	if f == "a1" {
+		return true
+	}
+	suffix := strings.TrimLeft(strings.TrimLeft(f, "abcdefghijklmnopqrstuvwxyz-"), "0123456789")
+	return strings.Contains(suffix, "g")
+}
+
+// paravirtualFamilies are the only families which run paravirtual images,
+// and pvOnlyFamilies those which run nothing else.
+var (
+	paravirtualFamilies = map[string]bool{"c1": true, "c3": true, "hs1": true, "m1": true, "m2": true, "m3": true, "t1": true}
+	pvOnlyFamilies      = map[string]bool{"c1": true, "m1": true, "m2": true, "t1": true}
+)
+
+// compatibleTypes returns the instance types the instance's image can run
+// on, judged by its architecture and virtualization type. The instance's
+// own type is always kept.
+func compatibleTypes(types []InstanceType, instance ec2.Instance) []InstanceType {
+	var compatible []InstanceType
+	for _, t := range types {
+		if t.Name != instance.InstanceType {
+			if (instance.Architecture == "arm64") != arm(t.Name) {
+				continue
+			}
+			switch f := family(t.Name); instance.VirtType {
+			case "paravirtual":
+				if !paravirtualFamilies[f] {
+					continue
+				}
+			case "hvm":
+				if pvOnlyFamilies[f] {
+					continue
+				}
+			}
+		}
+		compatible = append(compatible, t)
+	}
+	return compatible
+}
+
+// utilizationStore holds utilization uploaded by users, keyed by account,
+// region and instance ID, safe for concurrent use.
+type utilizationStore struct {
+	mu sync.Mutex
+	m  map[string]Utilization
+}
+
+func (s *utilizationStore) set(account, region, instanceId string, u Utilization) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]Utilization)
+	}
+	s.m[account+"/"+region+"/"+instanceId] = u
+}
+
+func (s *utilizationStore) get(account, region, instanceId string) (Utilization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.m[account+"/"+region+"/"+instanceId]
+	return u, ok
+}
+
+// utilization returns the utilization of an instance uploaded by a user of
+// the account, or fetches it from CloudWatch if none has been uploaded.
+func (app *App) utilization(ec2Cli *ec2.EC2, instanceId string) (Utilization, error) {
+	if u, ok := app.uploads.get(app.identity(ec2Cli).Account, ec2Cli.Region.Name, instanceId); ok {
+		return u, nil
+	}
+	cw := NewCloudWatch(ec2Cli.Auth, ec2Cli.Region, app.httpClient())
+	if app.CloudWatchEndpoint != "" {
+		cw.Endpoint = app.CloudWatchEndpoint
+	}
+	return FetchUtilization(cw, instanceId, time.Now())
+}
+
+// recommend builds a recommendation for an instance.
+func (app *App) recommend(ec2Cli *ec2.EC2, instance ec2.Instance, types []InstanceType) Recommendation {
+	u, err := app.utilization(ec2Cli, instance.InstanceId)
+	if err != nil {
+		return Recommendation{
+			InstanceId:  instance.InstanceId,
+			CurrentType: instance.InstanceType,
+			Action:      "unknown",
+			Reason:      err.Error(),
+		}
+	}
+	hourly := func(string) (float64, bool) { return 0, false }
+	if pricing := app.pricing(); pricing != nil {
+		key := priceKey(ec2Cli, instance)
+		hourly = func(name string) (float64, bool) {
+			k := key
+			k.InstanceType = name
+			cost, ok := pricing.Cost(k)
+			if !ok {
+				return 0, false
+			}
+			return cost.Hourly, true
+		}
+	}
+	return Recommend(instance.InstanceId, instance.InstanceType, u, compatibleTypes(types, instance), hourly)
+}
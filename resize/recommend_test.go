@@ -0,0 +1,238 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+var testTypes = []InstanceType{
+	{Name: "m3.medium", CPUs: 1, Memory: 3.75},
+	{Name: "m3.large", CPUs: 2, Memory: 7.5},
+	{Name: "m3.xlarge", CPUs: 4, Memory: 15},
+	{Name: "m3.2xlarge", CPUs: 8, Memory: 30},
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}
+	if p := percentile(values, 95); p != 10 {
+		t.Errorf("expected p95 of 10, got %g", p)
+	}
+	if p := percentile(values, 50); p != 5 {
+		t.Errorf("expected p50 of 5, got %g", p)
+	}
+	if p := percentile(nil, 95); p != 0 {
+		t.Errorf("expected p95 of no values to be 0, got %g", p)
+	}
+}
+
+func TestParseUtilizationCSV(t *testing.T) {
+	csv := `timestamp,cpu,memory,network_in,network_out
+2015-10-01T00:00:00Z,10.5,40,1000,2000
+2015-10-01T01:00:00Z,20,50,,3000
+2015-10-01T02:00:00Z,,60,1000,1000
+`
+	u, err := ParseUtilizationCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.CPU) != 2 || u.CPU[0] != 10.5 || u.CPU[1] != 20 {
+		t.Errorf("unexpected CPU utilization %v", u.CPU)
+	}
+	if len(u.Memory) != 2 {
+		t.Errorf("unexpected memory utilization %v", u.Memory)
+	}
+	if len(u.Network) != 2 || u.Network[0] != 3000 || u.Network[1] != 3000 {
+		t.Errorf("unexpected network utilization %v", u.Network)
+	}
+
+	if _, err := ParseUtilizationCSV(strings.NewReader("memory\n10\n")); err == nil {
+		t.Errorf("expected error for CSV without cpu column")
+	}
+	if _, err := ParseUtilizationCSV(strings.NewReader("cpu\nlots\n")); err == nil {
+		t.Errorf("expected error for non-numeric value")
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	prices := map[string]float64{
+		"m3.medium":  0.067,
+		"m3.large":   0.133,
+		"m3.xlarge":  0.266,
+		"m3.2xlarge": 0.532,
+	}
+	hourly := func(name string) (float64, bool) {
+		p, ok := prices[name]
+		return p, ok
+	}
+	repeat := func(v float64) []float64 {
+		values := make([]float64, 100)
+		for i := range values {
+			values[i] = v
+		}
+		return values
+	}
+	tests := []struct {
+		current   string
+		u         Utilization
+		action    string
+		suggested string
+	}{
+		// an idle m3.xlarge fits on an m3.medium
+		{"m3.xlarge", Utilization{CPU: repeat(5), Memory: repeat(10)}, "downsize", "m3.medium"},
+		// a busy m3.large needs more CPUs
+		{"m3.large", Utilization{CPU: repeat(95), Memory: repeat(10)}, "upsize", "m3.xlarge"},
+		{"m3.large", Utilization{CPU: repeat(60), Memory: repeat(50)}, "keep", "m3.large"},
+		// without memory data never suggest less memory
+		{"m3.xlarge", Utilization{CPU: repeat(5)}, "keep", "m3.xlarge"},
+		{"m3.large", Utilization{}, "unknown", ""},
+		{"t2.nano", Utilization{CPU: repeat(5)}, "unknown", ""},
+		// 50 MB/s of traffic needs more than the Moderate network of an m3.large
+		{"m3.large", Utilization{CPU: repeat(10), Memory: repeat(10), Network: repeat(50e6)}, "upsize", "m3.xlarge"},
+	}
+	types := append([]InstanceType(nil), testTypes...)
+	types[0].NetworkSpec = "Moderate"
+	types[1].NetworkSpec = "Moderate"
+	types[2].NetworkSpec = "High"
+	types[3].NetworkSpec = "High"
+	for _, test := range tests {
+		rec := Recommend("i-1234", test.current, test.u, types, hourly)
+		if rec.Action != test.action || rec.SuggestedType != test.suggested {
+			t.Errorf("%s: expected %s to %q, got %s to %q (%s)", test.current,
+				test.action, test.suggested, rec.Action, rec.SuggestedType, rec.Reason)
+		}
+	}
+
+	rec := Recommend("i-1234", "m3.xlarge", Utilization{CPU: repeat(5), Memory: repeat(10)}, testTypes, hourly)
+	if rec.CostDelta == nil {
+		t.Fatal("expected cost delta")
+	}
+	if delta := rec.CostDelta.Hourly; fmt.Sprintf("%.3f", delta) != "-0.199" {
+		t.Errorf("expected hourly cost delta of -0.199, got %g", delta)
+	}
+	// 5% of 4 CPUs on 1 CPU
+	if rec.ProjectedCPU != 20 || rec.CPUHeadroom != 80 {
+		t.Errorf("expected projected CPU of 20%%, got %g", rec.ProjectedCPU)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	tests := []struct {
+		spec  string
+		bw    float64
+		known bool
+	}{
+		{"Moderate", 500e6 / 8, true},
+		{"10 Gigabit", 10e9 / 8, true},
+		{"Up to 25 Gigabit", 25e9 / 8, true},
+		{"", 0, false},
+		{"Fast", 0, false},
+	}
+	for _, test := range tests {
+		bw, ok := InstanceType{NetworkSpec: test.spec}.bandwidth()
+		if bw != test.bw || ok != test.known {
+			t.Errorf("%q: expected %g (%t), got %g (%t)", test.spec, test.bw, test.known, bw, ok)
+		}
+	}
+}
+
+func TestCompatibleTypes(t *testing.T) {
+	types := []InstanceType{
+		{Name: "t1.micro"}, {Name: "m3.large"}, {Name: "m5.large"},
+		{Name: "m6g.large"}, {Name: "c7gn.large"}, {Name: "a1.large"},
+	}
+	names := func(types []InstanceType) string {
+		var n []string
+		for _, t := range types {
+			n = append(n, t.Name)
+		}
+		return strings.Join(n, " ")
+	}
+	tests := []struct {
+		instance ec2.Instance
+		expected string
+	}{
+		{ec2.Instance{InstanceType: "m5.large", Architecture: "x86_64", VirtType: "hvm"}, "m3.large m5.large"},
+		{ec2.Instance{InstanceType: "m3.large", Architecture: "x86_64", VirtType: "paravirtual"}, "t1.micro m3.large"},
+		{ec2.Instance{InstanceType: "m6g.large", Architecture: "arm64", VirtType: "hvm"}, "m6g.large c7gn.large a1.large"},
+	}
+	for _, test := range tests {
+		if got := names(compatibleTypes(types, test.instance)); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.instance.InstanceType, test.expected, got)
+		}
+	}
+}
+
+func TestUploadsByAccount(t *testing.T) {
+	var s utilizationStore
+	s.set("111", "us-east-1", "i-1234", Utilization{CPU: []float64{1}})
+	if _, ok := s.get("222", "us-east-1", "i-1234"); ok {
+		t.Errorf("expected another account not to see the upload")
+	}
+	if u, ok := s.get("111", "us-east-1", "i-1234"); !ok || len(u.CPU) != 1 {
+		t.Errorf("expected the upload, got %v", u)
+	}
+}
+
+// cloudWatchStandIn serves GetMetricStatistics responses with a constant
+// value for every metric.
+func cloudWatchStandIn(t *testing.T, values map[string]float64) *httptest.Server {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("Action") != "GetMetricStatistics" {
+			t.Errorf("unexpected action %s", q.Get("Action"))
+		}
+		if q.Get("Dimensions.member.1.Value") != "i-1234" {
+			t.Errorf("unexpected instance %s", q.Get("Dimensions.member.1.Value"))
+		}
+		if q.Get("Signature") == "" {
+			t.Errorf("request was not signed")
+		}
+		v, ok := values[q.Get("MetricName")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>InvalidParameterValue</Code><Message>no such metric</Message></Error></ErrorResponse>`)
+			return
+		}
+		stat := q.Get("Statistics.member.1")
+		fmt.Fprint(w, `<GetMetricStatisticsResponse><GetMetricStatisticsResult><Datapoints>`)
+		for i := 0; i < 3; i++ {
+			ts := time.Date(2015, 10, 1, i, 0, 0, 0, time.UTC).Format(time.RFC3339)
+			fmt.Fprintf(w, `<member><Timestamp>%s</Timestamp><%s>%g</%s></member>`, ts, stat, v, stat)
+		}
+		fmt.Fprint(w, `</Datapoints></GetMetricStatisticsResult></GetMetricStatisticsResponse>`)
+	}
+	return httptest.NewServer(http.HandlerFunc(hf))
+}
+
+func TestFetchUtilization(t *testing.T) {
+	s := cloudWatchStandIn(t, map[string]float64{
+		"CPUUtilization": 42,
+		"NetworkIn":      3600,
+		"NetworkOut":     7200,
+	})
+	defer s.Close()
+
+	cw := NewCloudWatch(aws.Auth{AccessKey: "AKID", SecretKey: "secret"}, aws.USEast, nil)
+	cw.Endpoint = s.URL
+	u, err := FetchUtilization(cw, "i-1234", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.CPU) != 3 || u.CPU[0] != 42 {
+		t.Errorf("unexpected CPU utilization %v", u.CPU)
+	}
+	if len(u.Memory) != 0 {
+		t.Errorf("expected no memory utilization, got %v", u.Memory)
+	}
+	// (3600 + 7200) bytes per hour
+	if len(u.Network) != 3 || u.Network[0] != 3 {
+		t.Errorf("unexpected network utilization %v", u.Network)
+	}
+}
@@ -0,0 +1,315 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/vaughan0/go-ini"
+)
+
+// awsRegions are the AWS regions known to goamz. Tests replace it to avoid
+// querying AWS.
+var awsRegions = aws.Regions
+
+// regionWorkers is the number of regions queried at once.
+const regionWorkers = 4
+
+// regionTimeout bounds the requests made to a single region.
+var regionTimeout = 20 * time.Second
+
+// LoadRegions reads additional regions from an INI file. Each section names
+// a region and sets its EC2 endpoint, for example:
+//
+//	[private-1]
+//	ec2_endpoint = https://ec2.private.example.com:8773/services/compute
+//
+// A section named after an AWS region overrides that region's endpoint.
+func LoadRegions(filename string) ([]aws.Region, error) {
+	file, err := ini.LoadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var regions []aws.Region
+	for name, section := range file {
+		if name == "" {
+			continue
+		}
+		endpoint := section["ec2_endpoint"]
+		if endpoint == "" {
+			return nil, fmt.Errorf("%s: region %s has no ec2_endpoint", filename, name)
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%s: region %s has an invalid ec2_endpoint %q", filename, name, endpoint)
+		}
+		regions = append(regions, aws.Region{Name: name, EC2Endpoint: endpoint})
+	}
+	sort.Sort(byName(regions))
+	return regions, nil
+}
+
+type byName []aws.Region
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// regions returns every region offered by the App: the AWS regions, sorted
+// by name, followed by any additional regions.
+func (app *App) regions() []aws.Region {
+	extra := make(map[string]aws.Region)
+	for _, region := range app.Regions {
+		extra[region.Name] = region
+	}
+	var regions []aws.Region
+	for name, region := range awsRegions {
+		if r, ok := extra[name]; ok {
+			region.EC2Endpoint = r.EC2Endpoint
+			delete(extra, name)
+		}
+		regions = append(regions, region)
+	}
+	sort.Sort(byName(regions))
+	for _, region := range app.Regions {
+		if _, ok := extra[region.Name]; ok {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// accountRegions returns the regions offered to the user of ec2Cli, leaving
+// out those which probing found the account cannot use. Users logged into a
+// provider only see its region.
+func (app *App) accountRegions(ec2Cli *ec2.EC2) []aws.Region {
+	if p, ok := app.provider(ec2Cli.Region.Name); ok {
+		return []aws.Region{p.Region()}
+	}
+	regions := app.regions()
+	if app.availability == nil {
+		return regions
+	}
+	unavailable := app.availability.get(ec2Cli.Auth.AccessKey)
+	var available []aws.Region
+	for _, region := range regions {
+		if !unavailable[region.Name] {
+			available = append(available, region)
+		}
+	}
+	return available
+}
+
+// region looks up a region offered to the user of ec2Cli by name.
+func (app *App) region(ec2Cli *ec2.EC2, name string) (aws.Region, bool) {
+	for _, region := range app.accountRegions(ec2Cli) {
+		if region.Name == name {
+			return region, true
+		}
+	}
+	return aws.Region{}, false
+}
+
+// unavailableCodes are the EC2 error codes returned by regions an account
+// cannot use, such as opt-in regions which are not enabled or partitions
+// like GovCloud which need separate credentials.
+var unavailableCodes = map[string]bool{
+	"AuthFailure":           true,
+	"OptInRequired":         true,
+	"InvalidClientTokenId":  true,
+	"UnauthorizedOperation": true,
+}
+
+// regionAvailability records, by access key ID, the regions each account
+// cannot use.
+type regionAvailability struct {
+	mu          sync.Mutex
+	unavailable map[string]map[string]bool
+}
+
+func newRegionAvailability() *regionAvailability {
+	return &regionAvailability{unavailable: make(map[string]map[string]bool)}
+}
+
+func (a *regionAvailability) get(accessKey string) map[string]bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.unavailable[accessKey]
+}
+
+func (a *regionAvailability) set(accessKey string, unavailable map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.unavailable[accessKey] = unavailable
+}
+
+// probeRegions checks which regions the holder of auth can use by making a
+// cheap call to each, in the manner of DescribeRegions. Regions which fail
+// for reasons other than the account, such as timeouts, stay available.
+func (app *App) probeRegions(auth aws.Auth) map[string]bool {
+	errs := app.eachRegion(auth, app.regions(), func(ec2Cli *ec2.EC2) error {
+		_, err := ec2Cli.DescribeAvailabilityZones(nil)
+		return err
+	})
+	unavailable := make(map[string]bool)
+	for name, err := range errs {
+		if ec2Err, ok := err.(*ec2.Error); ok && unavailableCodes[ec2Err.Code] {
+			unavailable[name] = true
+		}
+	}
+	if app.availability != nil {
+		app.availability.set(auth.AccessKey, unavailable)
+	}
+	return unavailable
+}
+
+// eachRegion calls f concurrently with a client for each region, using
+// at most regionWorkers goroutines. Requests made by f time out after
+// regionTimeout. It returns the errors returned by f, by region name.
+func (app *App) eachRegion(auth aws.Auth, regions []aws.Region, f func(ec2Cli *ec2.EC2) error) map[string]error {
+	client := *app.httpClient()
+	client.Timeout = regionTimeout
+
+	queue := make(chan aws.Region)
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < regionWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for region := range queue {
+				err := f(app.regionClient(auth, region, &client))
+				if err != nil {
+					mu.Lock()
+					errs[region.Name] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, region := range regions {
+		queue <- region
+	}
+	close(queue)
+	wg.Wait()
+	return errs
+}
+
+// RegionInstance is an instance found by a query across regions.
+type RegionInstance struct {
+	Region string
+	ec2.Instance
+}
+
+// RegionError records a region which could not be queried.
+type RegionError struct {
+	Region string
+	Error  string
+}
+
+// regionInstances calls find for every region the account can use and merges the instances it
+// returns. Regions which fail are returned alongside the instances found
+// elsewhere.
+func (app *App) regionInstances(ec2Cli *ec2.EC2, find func(ec2Cli *ec2.EC2) ([]ec2.Instance, error)) ([]RegionInstance, []RegionError) {
+	var mu sync.Mutex
+	var instances []RegionInstance
+	errs := app.eachRegion(ec2Cli.Auth, app.accountRegions(ec2Cli), func(ec2Cli *ec2.EC2) error {
+		found, err := find(ec2Cli)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, instance := range found {
+			instances = append(instances, RegionInstance{ec2Cli.Region.Name, instance})
+		}
+		return nil
+	})
+	sort.Sort(byRegion(instances))
+
+	var failed []RegionError
+	for name, err := range errs {
+		failed = append(failed, RegionError{name, err.Error()})
+	}
+	sort.Sort(byRegionError(failed))
+	return instances, failed
+}
+
+// describeAll returns a function describing the instances in a region
+// matching filter.
+func describeAll(filter *ec2.Filter) func(ec2Cli *ec2.EC2) ([]ec2.Instance, error) {
+	return func(ec2Cli *ec2.EC2) ([]ec2.Instance, error) {
+		resp, err := ec2Cli.Instances(nil, filter)
+		if err != nil {
+			return nil, err
+		}
+		return allInstances(resp), nil
+	}
+}
+
+type byRegion []RegionInstance
+
+func (s byRegion) Len() int      { return len(s) }
+func (s byRegion) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRegion) Less(i, j int) bool {
+	if s[i].Region != s[j].Region {
+		return s[i].Region < s[j].Region
+	}
+	return tagValue(s[i].Tags, "Name") < tagValue(s[j].Tags, "Name")
+}
+
+type byRegionError []RegionError
+
+func (s byRegionError) Len() int           { return len(s) }
+func (s byRegionError) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byRegionError) Less(i, j int) bool { return s[i].Region < s[j].Region }
+
+// Path: /all-regions
+func (app *App) handleAllRegions(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	query, err := ParseInventoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := query.Filter()
+	if query.State == "" {
+		filter = ec2.NewFilter()
+		for name, value := range query.filters() {
+			filter.Add(name, value)
+		}
+		// hide terminated instances unless asked for them
+		filter.Add("instance-state-name", "pending", "running", "stopping", "stopped", "shutting-down")
+	}
+	instances, failed := app.regionInstances(ec2Cli, describeAll(filter))
+	data := map[string]interface{}{
+		"Instances": instances,
+		"Failed":    failed,
+		"Query":     query,
+	}
+	app.render(w, r, "allregions.html", data)
+}
+
+// selectRegion switches the session to the named region.
+func (app *App) selectRegion(w http.ResponseWriter, r *http.Request, ec2Cli *ec2.EC2, name string) error {
+	region, ok := app.region(ec2Cli, name)
+	if !ok {
+		return fmt.Errorf("No AWS region named %s", name)
+	}
+	ec2Cli.Region = region
+	return app.set(w, r, ec2Cli)
+}
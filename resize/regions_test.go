@@ -0,0 +1,162 @@
+package resize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestRegionInstances(t *testing.T) {
+	east, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer east.Quit()
+	west, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer west.Quit()
+	east.NewInstances(2, "m1.small", "ami-1", ec2test.Running, nil)
+	west.NewInstances(1, "m1.small", "ami-1", ec2test.Running, nil)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer broken.Close()
+
+	defer func(regions map[string]aws.Region, timeout time.Duration) {
+		awsRegions, regionTimeout = regions, timeout
+	}(awsRegions, regionTimeout)
+	awsRegions = nil
+	regionTimeout = 100 * time.Millisecond
+
+	app := &App{HTTPClient: &http.Client{}, metrics: newMetrics()}
+	app.Regions = []aws.Region{
+		{Name: "test-east", EC2Endpoint: east.URL()},
+		{Name: "test-west", EC2Endpoint: west.URL()},
+		{Name: "test-slow", EC2Endpoint: slow.URL},
+		{Name: "test-broken", EC2Endpoint: broken.URL},
+	}
+	instances, failed := app.regionInstances(ec2.New(aws.Auth{}, aws.USEast), describeAll(nil))
+	if len(instances) != 3 {
+		t.Fatalf("expected 3 instances, got %d", len(instances))
+	}
+	if instances[0].Region != "test-east" || instances[2].Region != "test-west" {
+		t.Errorf("instances not sorted by region: %v", instances)
+	}
+	if len(failed) != 2 || failed[0].Region != "test-broken" || failed[1].Region != "test-slow" {
+		t.Errorf("expected the broken and slow regions to fail, got %v", failed)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	app := &App{Regions: []aws.Region{
+		{Name: "private-1", EC2Endpoint: "https://ec2.private.example.com"},
+		{Name: "us-east-1", EC2Endpoint: "http://localhost:8773"},
+	}}
+	regions := app.regions()
+	if len(regions) != len(aws.Regions)+1 {
+		t.Fatalf("expected %d regions, got %d", len(aws.Regions)+1, len(regions))
+	}
+	if last := regions[len(regions)-1]; last.Name != "private-1" {
+		t.Errorf("expected additional regions last, got %s", last.Name)
+	}
+	ec2Cli := ec2.New(aws.Auth{AccessKey: "AKID"}, aws.USEast)
+	if region, ok := app.region(ec2Cli, "us-east-1"); !ok || region.EC2Endpoint != "http://localhost:8773" {
+		t.Errorf("expected us-east-1 endpoint to be overridden, got %v", region)
+	}
+	if region, ok := app.region(ec2Cli, "us-west-2"); !ok || region.EC2Endpoint != aws.USWest2.EC2Endpoint {
+		t.Errorf("expected to find us-west-2, got %v", region)
+	}
+	if _, ok := app.region(ec2Cli, "moon-1"); ok {
+		t.Errorf("expected no region named moon-1")
+	}
+}
+
+func TestLoadRegions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "regions.ini")
+	config := "[private-2]\nec2_endpoint = https://two.example.com\n[private-1]\nec2_endpoint = https://one.example.com\n"
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	regions, err := LoadRegions(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []aws.Region{
+		{Name: "private-1", EC2Endpoint: "https://one.example.com"},
+		{Name: "private-2", EC2Endpoint: "https://two.example.com"},
+	}
+	if !reflect.DeepEqual(regions, want) {
+		t.Errorf("expected %v, got %v", want, regions)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("[private-3]\nec2_endpoint = not a url\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRegions(filename); err == nil {
+		t.Errorf("expected an error for an invalid endpoint")
+	}
+}
+
+func TestProbeRegions(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<DescribeAvailabilityZonesResponse><requestId>r</requestId><availabilityZoneInfo></availabilityZoneInfo></DescribeAvailabilityZonesResponse>`)
+	}))
+	defer ok.Close()
+	optIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>OptInRequired</Code><Message>not subscribed</Message></Error></Errors><RequestID>r</RequestID></Response>`)
+	}))
+	defer optIn.Close()
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer flaky.Close()
+
+	defer func(regions map[string]aws.Region) { awsRegions = regions }(awsRegions)
+	awsRegions = nil
+
+	app := &App{HTTPClient: &http.Client{}, availability: newRegionAvailability()}
+	app.Regions = []aws.Region{
+		{Name: "test-ok", EC2Endpoint: ok.URL},
+		{Name: "test-opt-in", EC2Endpoint: optIn.URL},
+		{Name: "test-flaky", EC2Endpoint: flaky.URL},
+	}
+	auth := aws.Auth{AccessKey: "AKID"}
+	unavailable := app.probeRegions(auth)
+	if !reflect.DeepEqual(unavailable, map[string]bool{"test-opt-in": true}) {
+		t.Errorf("expected only the opt-in region to be unavailable, got %v", unavailable)
+	}
+	var names []string
+	for _, region := range app.accountRegions(ec2.New(auth, aws.USEast)) {
+		names = append(names, region.Name)
+	}
+	if want := []string{"test-ok", "test-flaky"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected regions %v, got %v", want, names)
+	}
+	if n := len(app.accountRegions(ec2.New(aws.Auth{AccessKey: "OTHER"}, aws.USEast))); n != 3 {
+		t.Errorf("expected an unprobed account to see all 3 regions, got %d", n)
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
-	"path/filepath"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/mitchellh/goamz/aws"
-	"golang.org/x/net/websocket"
 )
 
 type App struct {
@@ -23,7 +25,8 @@ type App struct {
 	Logger *log.Logger
 
 	// ReloadTemplates specifies if the App will recompile
-	// the templates before rendering each response.
+	// the templates before rendering each response. It is only
+	// useful when the templates are read from disk.
 	// This option is intended for development, and should
 	// not be used on a production server.
 	ReloadTemplates bool
@@ -32,19 +35,145 @@ type App struct {
 	// If nil, the aws.Retrying client is used.
 	HTTPClient *http.Client
 
+	// AllowedOrigins lists the origins, such as "https://example.com",
+	// permitted to open websocket connections to the App.
+	// If empty, only the host the request was sent to is allowed.
+	AllowedOrigins []string
+
+	// ApprovalRule specifies which instances require a resize to be
+	// approved by a second user before it runs.
+	// If nil, no approval is required.
+	ApprovalRule *TagRule
+
+	// ApprovalTTL is how long an approval request stays open.
+	// If zero, requests expire after 24 hours.
+	ApprovalTTL time.Duration
+
+	// Notifier informs approvers of new approval requests.
+	// If nil, requests are logged.
+	Notifier Notifier
+
+	// AuditLog specifies an optional destination for a JSON line
+	// audit record of every mutating call made to AWS.
+	// Each record is written with a single call to Write.
+	AuditLog io.Writer
+
+	// PricingFile is the path of a local copy of the EC2 offer file, in the
+	// AWS Price List bulk JSON format, used to estimate the cost of resizes.
+	// It is read by LoadPricingFile or RefreshPricing. If empty, no costs
+	// are shown.
+	PricingFile string
+
+	// PricingURL is the offer file downloaded when pricing data is
+	// refreshed. If empty, DefaultPricingURL is used.
+	PricingURL string
+
+	// Recommend specifies if instance pages show rightsizing
+	// recommendations based on CloudWatch or uploaded utilization.
+	Recommend bool
+
+	// CloudWatchEndpoint overrides the CloudWatch endpoint of every region,
+	// for example to use a local stand-in.
+	CloudWatchEndpoint string
+
+	// SnapshotRetention is the age after which snapshots taken before
+	// resizes are offered for cleanup. If zero, seven days is used.
+	SnapshotRetention time.Duration
+
+	// ProtectedRule specifies which instances may not be stopped or
+	// terminated through the App, including by resizing them or changing
+	// their volumes while they are running.
+	// If nil, no instances are protected.
+	ProtectedRule *TagRule
+
+	// RequiredTags are tag rules every instance is expected to satisfy,
+	// such as an Owner tag. Instances missing them are flagged with a
+	// warning.
+	RequiredTags []TagRule
+
+	// Regions are additional regions, such as EC2-compatible endpoints,
+	// offered alongside the AWS regions. A region with the name of an AWS
+	// region overrides its EC2 endpoint.
+	Regions []aws.Region
+
+	// Providers are EC2-compatible private clouds users can log into
+	// instead of AWS.
+	Providers []Provider
+
+	// ReadyEndpoint is a URL, such as an EC2 endpoint, which must be
+	// reachable for /readyz to report the App ready.
+	// If empty, no endpoint is checked.
+	ReadyEndpoint string
+
+	// SessionTimeout is how long a login lasts, however active the session.
+	// If zero, sessions end after 12 hours.
+	SessionTimeout time.Duration
+
+	// IdleTimeout ends sessions which have not been used for this long.
+	// If zero, sessions end after an hour of inactivity.
+	IdleTimeout time.Duration
+
+	// Admins are the access key IDs of users who may view and revoke every
+	// user's sessions.
+	Admins []string
+
+	// BasePath is the path the App is mounted at behind a reverse proxy,
+	// such as "/tools/resize", without a trailing slash. Requests must
+	// include it, and links and redirects are made relative to it.
+	BasePath string
+
 	store *sessions.CookieStore
 
-	tmplDir string
+	approvals *approvals
+	history   *history
+	catalog   *catalog
+	metrics   *metrics
+	prices    *pricingStore
+	uploads   *utilizationStore
+	jobs      *jobs
+	sessions  *sessionRegistry
+
+	identities *identities
+
+	availability *regionAvailability
+	features     *featureSet
+
+	auditMu sync.Mutex
+
+	templates fs.FS
 
 	tmpl   map[string]*template.Template
 	router http.Handler
 }
 
-// NewApp initializes an App by parsing templates, and initializing
-// the internal path router.
+// NewApp initializes an App which serves static content and templates from
+// directories on disk.
 // If store is nil, a CookieStore with a random secret key is provided.
 func NewApp(static, templates string, store *sessions.CookieStore) (*App, error) {
-	app := &App{tmplDir: templates}
+	return NewAppFS(os.DirFS(static), os.DirFS(templates), store)
+}
+
+// NewAppFS initializes an App by parsing templates, and initializing
+// the internal path router. Static content and templates are read from the
+// given file systems, such as ones embedded in the binary.
+// If store is nil, a CookieStore with a random secret key is provided.
+func NewAppFS(static, templates fs.FS, store *sessions.CookieStore) (*App, error) {
+	app := &App{
+		templates: templates,
+		approvals: newApprovals(),
+		history:   &history{},
+		catalog:   &catalog{},
+		metrics:   newMetrics(),
+		prices:    &pricingStore{},
+		uploads:   &utilizationStore{},
+		jobs:      newJobs(),
+		sessions:  newSessionRegistry(),
+
+		identities: newIdentities(),
+
+		availability: newRegionAvailability(),
+		features:     newFeatureSet(),
+	}
 
 	err := app.compileTemplates(templates)
 	if err != nil {
@@ -62,16 +191,8 @@ func NewApp(static, templates string, store *sessions.CookieStore) (*App, error)
 		app.store = sessions.NewCookieStore(secretKey)
 	}
 
-	// helper functions for serving static assets
-	serveDir := func(path string) http.Handler {
-		return http.FileServer(http.Dir(filepath.Join(static, path)))
-	}
-	serveFile := func(path string) http.Handler {
-		fp := filepath.Join(static, path)
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFile(w, r, fp)
-		})
-	}
+	// static assets are served at the same paths they have in static
+	serveStatic := http.FileServer(http.FS(static))
 
 	// restrict a handle to only those which have logged in
 	restrict := func(hf http.HandlerFunc) http.Handler { return app.restrict(hf) }
@@ -79,32 +200,63 @@ func NewApp(static, templates string, store *sessions.CookieStore) (*App, error)
 	// Define routes
 	r := mux.NewRouter()
 
-	r.PathPrefix("/css/").Handler(http.StripPrefix("/css/", serveDir("css")))
-	r.PathPrefix("/js/").Handler(http.StripPrefix("/js/", serveDir("js")))
-	r.PathPrefix("/img/").Handler(http.StripPrefix("/img/", serveDir("img")))
+	r.PathPrefix("/css/").Handler(serveStatic)
+	r.PathPrefix("/js/").Handler(serveStatic)
+	r.PathPrefix("/img/").Handler(serveStatic)
 
-	r.Handle("/favicon.ico", serveFile("favicon.ico"))
+	r.Handle("/favicon.ico", serveStatic)
 
 	r.HandleFunc("/login", app.handleLogin)
 	r.HandleFunc("/logout", app.handleLogout)
 	r.HandleFunc("/about", app.handleAbout)
+	r.HandleFunc("/metrics", app.handleMetrics)
+	r.HandleFunc("/healthz", app.handleHealthz)
+	r.HandleFunc("/readyz", app.handleReadyz)
 
 	r.Handle("/", restrict(app.handleIndex))
 	r.Handle("/region", restrict(app.handleRegion))
+	r.Handle("/all-regions", restrict(app.handleAllRegions))
+	r.Handle("/search", restrict(app.handleSearch))
 	r.Handle("/instance/{instance}", restrict(app.handleInstance))
+	r.Handle("/instance/{instance}/utilization", restrict(app.handleUtilization))
+	r.Handle("/instance/{instance}/tags", restrict(app.handleTags))
+	r.Handle("/tags", restrict(app.handleBulkTag))
+	r.Handle("/instance/{instance}/security-groups/{group}", restrict(app.handleSecurityGroup))
+	r.Handle("/recommendations", restrict(app.handleRecommendations))
+	r.Handle("/approvals", restrict(app.handleApprovals))
+	r.Handle("/approvals/{approval}", restrict(app.handleDecide))
+	r.Handle("/history", restrict(app.handleHistory))
+	r.Handle("/sessions", restrict(app.handleSessions))
+	r.Handle("/sessions/revoke", restrict(app.handleRevokeSessions))
+	r.Handle("/jobs/{job}", restrict(app.handleJob))
+	r.Handle("/jobs/{job}/recover", restrict(app.handleRecover))
+	r.Handle("/snapshots", restrict(app.handleSnapshots))
+	r.Handle("/snapshots/cleanup", restrict(app.handleSnapshotCleanup))
+	r.Handle("/pricing/refresh", restrict(app.handleRefreshPricing))
+	r.Handle("/addresses", restrict(app.handleAddresses))
+	r.Handle("/addresses/allocate", restrict(app.handleAllocateAddress))
+	r.Handle("/addresses/{address}/{action}", restrict(app.handleAddressAction))
 	r.Handle("/instance/{instance}/resize",
-		websocket.Handler(app.handleResize))
+		app.wsHandler(app.handleResize))
 	r.Handle("/instance/{instance}/assign-ip",
-		websocket.Handler(app.handleAssignIp))
+		app.wsHandler(app.handleAssignIp))
+	r.Handle("/instance/{instance}/volume",
+		app.wsHandler(app.handleVolume))
+	r.Handle("/instance/{instance}/lifecycle",
+		app.wsHandler(app.handleLifecycle))
 
 	r.NotFoundHandler = http.HandlerFunc(app.render404)
-	app.router = r
+	app.router = app.csrf(r)
 
 	return app, nil
 }
 
 // App implements the http.Handler interface
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if app.BasePath != "" && !isProbe(r.URL.Path) {
+		app.serveMounted(w, r)
+		return
+	}
 	app.router.ServeHTTP(w, r)
 }
 
@@ -119,7 +271,7 @@ func (app *App) Logf(format string, a ...interface{}) {
 
 func (app *App) httpClient() *http.Client {
 	if app.HTTPClient == nil {
-		return aws.RetryingClient
+		return app.instrumentClient(aws.RetryingClient)
 	}
-	return app.HTTPClient
+	return app.instrumentClient(app.HTTPClient)
 }
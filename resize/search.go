@@ -0,0 +1,113 @@
+package resize
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+var instanceIdRegexp = regexp.MustCompile(`^i-[0-9a-f]+$`)
+
+// searchFilters returns the names of the DescribeInstances filters which
+// could match a search term. EC2 ANDs filters together, so each is queried
+// separately.
+func searchFilters(term string) []string {
+	switch {
+	case instanceIdRegexp.MatchString(term):
+		return []string{"instance-id"}
+	case net.ParseIP(term) != nil:
+		return []string{"ip-address", "private-ip-address"}
+	case strings.Contains(term, "."):
+		return []string{"dns-name", "private-dns-name", "tag-value"}
+	}
+	return []string{"tag-value"}
+}
+
+// searchRegion finds the instances in one region matching a search term.
+// IP addresses are also looked up as Elastic IPs.
+func searchRegion(ec2Cli *ec2.EC2, term string) ([]ec2.Instance, error) {
+	var found []ec2.Instance
+	seen := make(map[string]bool)
+	add := func(instances []ec2.Instance) {
+		for _, instance := range instances {
+			if !seen[instance.InstanceId] {
+				seen[instance.InstanceId] = true
+				found = append(found, instance)
+			}
+		}
+	}
+	for _, name := range searchFilters(term) {
+		filter := ec2.NewFilter()
+		filter.Add(name, term)
+		resp, err := ec2Cli.Instances(nil, filter)
+		if err != nil {
+			return nil, err
+		}
+		add(allInstances(resp))
+	}
+	if net.ParseIP(term) == nil {
+		return found, nil
+	}
+
+	filter := ec2.NewFilter()
+	filter.Add("public-ip", term)
+	resp, err := ec2Cli.Addresses(nil, nil, filter)
+	if notImplemented(err) {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, addr := range resp.Addresses {
+		if addr.InstanceId != "" && !seen[addr.InstanceId] {
+			ids = append(ids, addr.InstanceId)
+		}
+	}
+	if len(ids) > 0 {
+		resp, err := ec2Cli.Instances(ids, nil)
+		if err != nil {
+			return nil, err
+		}
+		add(allInstances(resp))
+	}
+	return found, nil
+}
+
+// Path: /search
+func (app *App) handleSearch(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	term := strings.TrimSpace(r.FormValue("q"))
+	if term == "" {
+		http.Redirect(w, r, app.url("/"), http.StatusSeeOther)
+		return
+	}
+
+	instances, failed := app.regionInstances(ec2Cli, func(ec2Cli *ec2.EC2) ([]ec2.Instance, error) {
+		return searchRegion(ec2Cli, term)
+	})
+
+	// a single match goes straight to the instance
+	if len(instances) == 1 && len(failed) == 0 {
+		match := instances[0]
+		http.Redirect(w, r, app.url("/instance/"+match.InstanceId+"?region="+match.Region), http.StatusSeeOther)
+		return
+	}
+	data := map[string]interface{}{
+		"Term":      term,
+		"Instances": instances,
+		"Failed":    failed,
+	}
+	app.render(w, r, "search.html", data)
+}
@@ -0,0 +1,111 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestSearchFilters(t *testing.T) {
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"i-0abc123", []string{"instance-id"}},
+		{"10.0.0.12", []string{"ip-address", "private-ip-address"}},
+		{"ip-10-0-0-12.ec2.internal", []string{"dns-name", "private-dns-name", "tag-value"}},
+		{"web-1", []string{"tag-value"}},
+	}
+	for _, test := range tests {
+		if got := searchFilters(test.term); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected filters %v, got %v", test.term, test.want, got)
+		}
+	}
+}
+
+// searchServer is a stand-in for DescribeInstances and DescribeAddresses
+// which matches single filters against a fixed set of instances.
+type searchServer struct {
+	mu        sync.Mutex
+	instances map[string]map[string]string // instance ID to filter values
+	addresses map[string]string            // Elastic IP to instance ID
+}
+
+func (s *searchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	name, value := q.Get("Filter.1.Name"), q.Get("Filter.1.Value.1")
+	switch q.Get("Action") {
+	case "DescribeInstances":
+		fmt.Fprint(w, `<DescribeInstancesResponse><requestId>r</requestId><reservationSet>`)
+		for id, values := range s.instances {
+			if (name != "" && values[name] == value) || (name == "" && q.Get("InstanceId.1") == id) {
+				fmt.Fprintf(w, `<item><reservationId>r-1</reservationId><instancesSet><item><instanceId>%s</instanceId></item></instancesSet></item>`, id)
+			}
+		}
+		fmt.Fprint(w, `</reservationSet></DescribeInstancesResponse>`)
+	case "DescribeAddresses":
+		fmt.Fprint(w, `<DescribeAddressesResponse><requestId>r</requestId><addressesSet>`)
+		if id, ok := s.addresses[value]; ok && name == "public-ip" {
+			fmt.Fprintf(w, `<item><publicIp>%s</publicIp><instanceId>%s</instanceId></item>`, value, id)
+		}
+		fmt.Fprint(w, `</addressesSet></DescribeAddressesResponse>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestSearchRegion(t *testing.T) {
+	srv := &searchServer{
+		instances: map[string]map[string]string{
+			"i-1": {"private-ip-address": "10.0.0.1", "tag-value": "web"},
+			"i-2": {"ip-address": "54.0.0.2", "dns-name": "ec2-54-0-0-2.compute.amazonaws.com", "tag-value": "web"},
+			"i-3": {"tag-value": "db"},
+		},
+		addresses: map[string]string{"54.0.0.3": "i-3"},
+	}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"10.0.0.1", []string{"i-1"}},
+		{"54.0.0.2", []string{"i-2"}},
+		{"54.0.0.3", []string{"i-3"}},
+		{"ec2-54-0-0-2.compute.amazonaws.com", []string{"i-2"}},
+		{"db", []string{"i-3"}},
+		{"10.9.9.9", nil},
+	}
+	for _, test := range tests {
+		found, err := searchRegion(ec2Cli, test.term)
+		if err != nil {
+			t.Errorf("%s: %v", test.term, err)
+			continue
+		}
+		var got []string
+		for _, instance := range found {
+			got = append(got, instance.InstanceId)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.term, test.want, got)
+		}
+	}
+
+	found, err := searchRegion(ec2Cli, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected two instances tagged web, got %v", found)
+	}
+}
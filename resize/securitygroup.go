@@ -0,0 +1,303 @@
+package resize
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// Rule is a single security group permission: traffic in one direction
+// for a protocol and port range from (or to) one CIDR block or group.
+type Rule struct {
+	Direction string // "ingress" or "egress"
+	Protocol  string
+	FromPort  int
+	ToPort    int
+	Source    string // CIDR block or security group ID or name
+	UserId    string // account owning the source group, if another account
+}
+
+func (rule Rule) String() string {
+	ports := "all ports"
+	switch {
+	case rule.Protocol == "-1":
+		return fmt.Sprintf("all traffic %s %s", rule.preposition(), rule.source())
+	case rule.Protocol == "icmp":
+		ports = fmt.Sprintf("type %d code %d", rule.FromPort, rule.ToPort)
+	case rule.FromPort == rule.ToPort:
+		ports = fmt.Sprintf("port %d", rule.FromPort)
+	case rule.FromPort != 0 || rule.ToPort != 65535:
+		ports = fmt.Sprintf("ports %d-%d", rule.FromPort, rule.ToPort)
+	}
+	return fmt.Sprintf("%s %s %s %s", rule.Protocol, ports, rule.preposition(), rule.source())
+}
+
+// source returns the rule's source, prefixed by the account owning it if
+// it is another account's group.
+func (rule Rule) source() string {
+	if rule.UserId != "" {
+		return rule.UserId + "/" + rule.Source
+	}
+	return rule.Source
+}
+
+func (rule Rule) preposition() string {
+	if rule.Direction == "egress" {
+		return "to"
+	}
+	return "from"
+}
+
+// perm converts the rule to the form expected by the EC2 API.
+func (rule Rule) perm() ec2.IPPerm {
+	perm := ec2.IPPerm{Protocol: rule.Protocol, FromPort: rule.FromPort, ToPort: rule.ToPort}
+	if _, _, err := net.ParseCIDR(rule.Source); err == nil {
+		perm.SourceIPs = []string{rule.Source}
+	} else if strings.HasPrefix(rule.Source, "sg-") {
+		perm.SourceGroups = []ec2.UserSecurityGroup{{Id: rule.Source, OwnerId: rule.UserId}}
+	} else {
+		perm.SourceGroups = []ec2.UserSecurityGroup{{Name: rule.Source, OwnerId: rule.UserId}}
+	}
+	return perm
+}
+
+// flattenRules splits a group's permissions into one rule per source.
+func flattenRules(group ec2.SecurityGroupInfo) []Rule {
+	var rules []Rule
+	add := func(direction string, perms []ec2.IPPerm) {
+		for _, perm := range perms {
+			rule := Rule{Direction: direction, Protocol: perm.Protocol, FromPort: perm.FromPort, ToPort: perm.ToPort}
+			for _, ip := range perm.SourceIPs {
+				rule.Source = ip
+				rules = append(rules, rule)
+			}
+			for _, g := range perm.SourceGroups {
+				rule.Source = g.Id
+				if rule.Source == "" {
+					rule.Source = g.Name
+				}
+				rule.UserId = ""
+				if g.OwnerId != group.OwnerId {
+					rule.UserId = g.OwnerId
+				}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	add("ingress", group.IPPerms)
+	add("egress", group.IPPermsEgress)
+	return rules
+}
+
+// parseRule reads a rule from the direction, protocol, from, to, source and
+// user form values.
+func parseRule(r *http.Request) (Rule, error) {
+	rule := Rule{
+		Direction: r.FormValue("direction"),
+		Protocol:  r.FormValue("protocol"),
+		Source:    strings.TrimSpace(r.FormValue("source")),
+		UserId:    strings.TrimSpace(r.FormValue("user")),
+	}
+	if rule.Direction != "ingress" && rule.Direction != "egress" {
+		return rule, fmt.Errorf("direction must be 'ingress' or 'egress'")
+	}
+	switch rule.Protocol {
+	case "tcp", "udp", "icmp":
+		var err error
+		if rule.FromPort, err = strconv.Atoi(r.FormValue("from")); err != nil {
+			return rule, fmt.Errorf("invalid from port %q", r.FormValue("from"))
+		}
+		if rule.ToPort, err = strconv.Atoi(r.FormValue("to")); err != nil {
+			return rule, fmt.Errorf("invalid to port %q", r.FormValue("to"))
+		}
+		if rule.Protocol != "icmp" && (rule.FromPort < 0 || rule.ToPort > 65535 || rule.FromPort > rule.ToPort) {
+			return rule, fmt.Errorf("invalid port range %d-%d", rule.FromPort, rule.ToPort)
+		}
+	case "-1":
+		rule.FromPort, rule.ToPort = 0, 0
+	default:
+		return rule, fmt.Errorf("unknown protocol %q", rule.Protocol)
+	}
+	if rule.Source == "" {
+		return rule, fmt.Errorf("no source provided")
+	}
+	if strings.Contains(rule.Source, "/") {
+		if _, _, err := net.ParseCIDR(rule.Source); err != nil {
+			return rule, fmt.Errorf("invalid CIDR block %q", rule.Source)
+		}
+		if rule.UserId != "" {
+			return rule, fmt.Errorf("only security group sources belong to an account")
+		}
+	}
+	return rule, nil
+}
+
+// RuleDiff is a line of a preview of a change to a group's rules.
+type RuleDiff struct {
+	Rule
+	Change string // "+", "-" or ""
+}
+
+// diffRules previews authorizing or revoking a rule. It is an error to
+// authorize a rule which exists or revoke one which does not.
+func diffRules(current []Rule, op string, rule Rule) ([]RuleDiff, error) {
+	var diff []RuleDiff
+	found := false
+	for _, r := range current {
+		d := RuleDiff{Rule: r}
+		if r == rule {
+			found = true
+			if op == "revoke" {
+				d.Change = "-"
+			}
+		}
+		diff = append(diff, d)
+	}
+	switch op {
+	case "authorize":
+		if found {
+			return nil, fmt.Errorf("rule %s already exists", rule)
+		}
+		diff = append(diff, RuleDiff{Rule: rule, Change: "+"})
+	case "revoke":
+		if !found {
+			return nil, fmt.Errorf("rule %s does not exist", rule)
+		}
+	default:
+		return nil, fmt.Errorf("operation must be 'authorize' or 'revoke'")
+	}
+	return diff, nil
+}
+
+// applyRule authorizes or revokes a rule on a group.
+func applyRule(ec2Cli *ec2.EC2, group ec2.SecurityGroup, op string, rule Rule) error {
+	perms := []ec2.IPPerm{rule.perm()}
+	var err error
+	switch {
+	case op == "authorize" && rule.Direction == "ingress":
+		_, err = ec2Cli.AuthorizeSecurityGroup(group, perms)
+	case op == "authorize" && rule.Direction == "egress":
+		_, err = ec2Cli.AuthorizeSecurityGroupEgress(group, perms)
+	case op == "revoke" && rule.Direction == "ingress":
+		_, err = ec2Cli.RevokeSecurityGroup(group, perms)
+	case op == "revoke" && rule.Direction == "egress":
+		_, err = ec2Cli.RevokeSecurityGroupEgress(group, perms)
+	default:
+		return fmt.Errorf("operation must be 'authorize' or 'revoke'")
+	}
+	if err != nil {
+		return fmt.Errorf("could not %s rule: %v", op, err)
+	}
+	return nil
+}
+
+// groupRules is a security group and its flattened rules.
+type groupRules struct {
+	Group ec2.SecurityGroupInfo
+	Rules []Rule
+}
+
+// instanceGroups describes the security groups attached to an instance.
+func instanceGroups(ec2Cli *ec2.EC2, instance ec2.Instance) ([]ec2.SecurityGroupInfo, error) {
+	if len(instance.SecurityGroups) == 0 {
+		return nil, nil
+	}
+	var groups []ec2.SecurityGroup
+	for _, g := range instance.SecurityGroups {
+		groups = append(groups, ec2.SecurityGroup{Id: g.Id, Name: g.Name})
+	}
+	resp, err := ec2Cli.SecurityGroups(groups, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Groups, nil
+}
+
+// instanceGroup looks up a security group by ID, checking it is attached to
+// the instance.
+func instanceGroup(ec2Cli *ec2.EC2, instanceId, groupId string) (ec2.SecurityGroupInfo, error) {
+	resp, err := ec2Cli.Instances([]string{instanceId}, nil)
+	if err != nil {
+		return ec2.SecurityGroupInfo{}, fmt.Errorf("Bad response from AWS %v", err)
+	}
+	instances := allInstances(resp)
+	if len(instances) != 1 {
+		return ec2.SecurityGroupInfo{}, fmt.Errorf("instance %s not found", instanceId)
+	}
+	groups, err := instanceGroups(ec2Cli, instances[0])
+	if err != nil {
+		return ec2.SecurityGroupInfo{}, fmt.Errorf("Bad response from AWS %v", err)
+	}
+	for _, g := range groups {
+		if g.Id == groupId {
+			return g, nil
+		}
+	}
+	return ec2.SecurityGroupInfo{}, fmt.Errorf("security group %s is not attached to %s", groupId, instanceId)
+}
+
+// Path: /instance/{instance}/security-groups/{group}
+func (app *App) handleSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	vars := mux.Vars(r)
+	instanceId := vars["instance"]
+	group, err := instanceGroup(ec2Cli, instanceId, vars["group"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	op := r.FormValue("op")
+	rule, err := parseRule(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.Direction == "egress" && group.VpcId == "" {
+		http.Error(w, "Egress rules can only be changed on VPC security groups", http.StatusBadRequest)
+		return
+	}
+	diff, err := diffRules(flattenRules(group), op, rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the first submission shows a preview, the change is only made once
+	// it has been confirmed
+	if r.FormValue("confirm") != "true" {
+		data := map[string]interface{}{
+			"InstanceId": instanceId,
+			"Group":      group,
+			"Op":         op,
+			"Rule":       rule,
+			"Diff":       diff,
+		}
+		app.render(w, r, "securitygroup.html", data)
+		return
+	}
+	if err := applyRule(ec2Cli, group.SecurityGroup, op, rule); err != nil {
+		app.render500(w, r, err)
+		return
+	}
+	app.record(ec2Cli, HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     ec2Cli.Region.Name,
+		InstanceId: instanceId,
+		Action:     "security-group",
+		Message:    fmt.Sprintf("%sd %s rule %s on %s", op, rule.Direction, rule, group.Id),
+	})
+	http.Redirect(w, r, app.url("/instance/"+instanceId), http.StatusSeeOther)
+}
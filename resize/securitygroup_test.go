@@ -0,0 +1,166 @@
+package resize
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		form url.Values
+		want Rule
+		ok   bool
+	}{
+		{url.Values{"direction": {"ingress"}, "protocol": {"tcp"}, "from": {"22"}, "to": {"22"}, "source": {"10.0.0.0/8"}},
+			Rule{"ingress", "tcp", 22, 22, "10.0.0.0/8", ""}, true},
+		{url.Values{"direction": {"egress"}, "protocol": {"-1"}, "source": {"sg-1234"}},
+			Rule{"egress", "-1", 0, 0, "sg-1234", ""}, true},
+		{url.Values{"direction": {"ingress"}, "protocol": {"tcp"}, "from": {"5432"}, "to": {"5432"}, "source": {"sg-5678"}, "user": {"222"}},
+			Rule{"ingress", "tcp", 5432, 5432, "sg-5678", "222"}, true},
+		{url.Values{"direction": {"ingress"}, "protocol": {"-1"}, "source": {"10.0.0.0/8"}, "user": {"222"}},
+			Rule{}, false},
+		{url.Values{"direction": {"ingress"}, "protocol": {"tcp"}, "from": {"443"}, "to": {"80"}, "source": {"10.0.0.0/8"}},
+			Rule{}, false},
+		{url.Values{"direction": {"ingress"}, "protocol": {"tcp"}, "from": {"22"}, "to": {"22"}, "source": {"10.0.0.0/33"}},
+			Rule{}, false},
+		{url.Values{"direction": {"ingress"}, "protocol": {"gre"}, "source": {"10.0.0.0/8"}},
+			Rule{}, false},
+		{url.Values{"direction": {"sideways"}, "protocol": {"-1"}, "source": {"10.0.0.0/8"}},
+			Rule{}, false},
+		{url.Values{"direction": {"ingress"}, "protocol": {"-1"}},
+			Rule{}, false},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rule, err := parseRule(r)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%v: expected ok=%t, got error %v", test.form, test.ok, err)
+			continue
+		}
+		if test.ok && rule != test.want {
+			t.Errorf("%v: expected %+v, got %+v", test.form, test.want, rule)
+		}
+	}
+}
+
+func TestDiffRules(t *testing.T) {
+	ssh := Rule{"ingress", "tcp", 22, 22, "10.0.0.0/8", ""}
+	web := Rule{"ingress", "tcp", 80, 80, "0.0.0.0/0", ""}
+	current := []Rule{ssh}
+
+	diff, err := diffRules(current, "authorize", web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 2 || diff[0].Change != "" || diff[1].Change != "+" || diff[1].Rule != web {
+		t.Errorf("unexpected authorize diff %v", diff)
+	}
+	diff, err = diffRules(current, "revoke", ssh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff[0].Change != "-" {
+		t.Errorf("unexpected revoke diff %v", diff)
+	}
+	if _, err := diffRules(current, "authorize", ssh); err == nil {
+		t.Errorf("expected error authorizing an existing rule")
+	}
+	if _, err := diffRules(current, "revoke", web); err == nil {
+		t.Errorf("expected error revoking a missing rule")
+	}
+}
+
+func TestFlattenRules(t *testing.T) {
+	info := ec2.SecurityGroupInfo{
+		OwnerId: "111",
+		IPPerms: []ec2.IPPerm{{
+			Protocol: "tcp", FromPort: 5432, ToPort: 5432,
+			SourceIPs:    []string{"10.0.0.0/8"},
+			SourceGroups: []ec2.UserSecurityGroup{{Id: "sg-1", OwnerId: "111"}, {Id: "sg-2", OwnerId: "222"}},
+		}},
+	}
+	want := []Rule{
+		{"ingress", "tcp", 5432, 5432, "10.0.0.0/8", ""},
+		{"ingress", "tcp", 5432, 5432, "sg-1", ""},
+		{"ingress", "tcp", 5432, 5432, "sg-2", "222"},
+	}
+	rules := flattenRules(info)
+	if len(rules) != len(want) {
+		t.Fatalf("expected %v, got %v", want, rules)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], rules[i])
+		}
+	}
+	if s := rules[2].String(); s != "tcp port 5432 from 222/sg-2" {
+		t.Errorf("unexpected description %q", s)
+	}
+	if perm := rules[2].perm(); len(perm.SourceGroups) != 1 || perm.SourceGroups[0].OwnerId != "222" {
+		t.Errorf("expected the owner of the source group in %+v", perm)
+	}
+}
+
+func TestSecurityGroupRules(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: srv.URL()})
+
+	created, err := ec2Cli.CreateSecurityGroup(ec2.SecurityGroup{Name: "web", Description: "web servers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := created.SecurityGroup
+	resp, err := ec2Cli.RunInstances(&ec2.RunInstances{
+		ImageId:        "ami-1234",
+		InstanceType:   "t2.small",
+		MinCount:       1,
+		MaxCount:       1,
+		SecurityGroups: []ec2.SecurityGroup{group},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceId := resp.Instances[0].InstanceId
+
+	ssh := Rule{"ingress", "tcp", 22, 22, "10.0.0.0/8", ""}
+	if err := applyRule(ec2Cli, group, "authorize", ssh); err != nil {
+		t.Fatal(err)
+	}
+	info, err := instanceGroup(ec2Cli, instanceId, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := flattenRules(info)
+	if len(rules) != 1 || rules[0] != ssh {
+		t.Fatalf("expected only %v, got %v", ssh, rules)
+	}
+	if err := applyRule(ec2Cli, group, "authorize", ssh); err == nil {
+		t.Errorf("expected error authorizing a duplicate rule")
+	}
+
+	if err := applyRule(ec2Cli, group, "revoke", ssh); err != nil {
+		t.Fatal(err)
+	}
+	info, err = instanceGroup(ec2Cli, instanceId, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := flattenRules(info); len(rules) != 0 {
+		t.Errorf("expected no rules after revoking, got %v", rules)
+	}
+
+	if _, err := instanceGroup(ec2Cli, instanceId, "sg-nothere"); err == nil {
+		t.Errorf("expected error for a group not attached to the instance")
+	}
+}
@@ -0,0 +1,244 @@
+package resize
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSessionTimeout is used if the App's SessionTimeout is not set.
+	defaultSessionTimeout = 12 * time.Hour
+	// defaultIdleTimeout is used if the App's IdleTimeout is not set.
+	defaultIdleTimeout = time.Hour
+	// sweepInterval is how often touch removes timed out sessions.
+	sweepInterval = time.Minute
+)
+
+// Session is a logged in session, as listed on the sessions page.
+type Session struct {
+	Id         string
+	User       string
+	RemoteAddr string
+	UserAgent  string
+	Created    time.Time
+	LastSeen   time.Time
+}
+
+// sessionRegistry tracks logged in sessions in memory, safe for concurrent
+// use.
+//
+// Session cookies carry their login time, so the absolute timeout holds
+// across restarts, but idle time and revocations are only known to the
+// process which saw them.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	// ended holds the login time of sessions which were logged out or went
+	// idle, until they would have timed out anyway.
+	ended map[string]time.Time
+	// revoked holds the time each user last logged out everywhere. Sessions
+	// created before it are no longer valid.
+	revoked map[string]time.Time
+	// swept is when timed out sessions were last removed.
+	swept time.Time
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[string]*Session),
+		ended:    make(map[string]time.Time),
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// expire removes sessions which have timed out, and revocations which no
+// longer apply to any session. The caller must hold the lock.
+func (s *sessionRegistry) expire(timeout, idle time.Duration) {
+	now := time.Now()
+	s.swept = now
+	for id, sess := range s.sessions {
+		switch {
+		case now.Sub(sess.Created) > timeout:
+			delete(s.sessions, id)
+		case now.Sub(sess.LastSeen) > idle:
+			delete(s.sessions, id)
+			s.ended[id] = sess.Created
+		}
+	}
+	for id, created := range s.ended {
+		if now.Sub(created) > timeout {
+			delete(s.ended, id)
+		}
+	}
+	for user, revoked := range s.revoked {
+		if now.Sub(revoked) > timeout {
+			delete(s.revoked, user)
+		}
+	}
+}
+
+// touch reports if the session is still valid and, if so, records that it
+// was just used by r. Sessions not yet tracked, such as ones created before
+// a restart, are tracked from now on. Every sweepInterval it also removes
+// timed out sessions, so the registry does not grow without bound.
+func (s *sessionRegistry) touch(timeout, idle time.Duration, id, user string, created time.Time, r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		s.expire(timeout, idle)
+	}
+	if now.Sub(created) > timeout {
+		delete(s.sessions, id)
+		return false
+	}
+	if revoked, ok := s.revoked[user]; ok && created.Before(revoked) {
+		return false
+	}
+	if _, ok := s.ended[id]; ok {
+		return false
+	}
+	sess, ok := s.sessions[id]
+	if ok && now.Sub(sess.LastSeen) > idle {
+		delete(s.sessions, id)
+		s.ended[id] = created
+		return false
+	}
+	if !ok {
+		sess = &Session{Id: id, User: user, Created: created}
+		s.sessions[id] = sess
+	}
+	sess.LastSeen = now
+	sess.RemoteAddr = r.RemoteAddr
+	sess.UserAgent = r.UserAgent()
+	return true
+}
+
+// end logs out a single session.
+func (s *sessionRegistry) end(id string, created time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	s.ended[id] = created
+}
+
+// revoke logs out every session of the user, returning how many were being
+// tracked.
+func (s *sessionRegistry) revoke(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[user] = time.Now()
+	n := 0
+	for id, sess := range s.sessions {
+		if sess.User == user {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+// list returns the active sessions of user, or of every user if user is
+// empty, most recently used first.
+func (s *sessionRegistry) list(timeout, idle time.Duration, user string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(timeout, idle)
+	var list []Session
+	for _, sess := range s.sessions {
+		if user == "" || sess.User == user {
+			list = append(list, *sess)
+		}
+	}
+	sort.Sort(byLastSeen(list))
+	return list
+}
+
+type byLastSeen []Session
+
+func (s byLastSeen) Len() int           { return len(s) }
+func (s byLastSeen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLastSeen) Less(i, j int) bool { return s[i].LastSeen.After(s[j].LastSeen) }
+
+func (app *App) sessionTimeout() time.Duration {
+	if app.SessionTimeout <= 0 {
+		return defaultSessionTimeout
+	}
+	return app.SessionTimeout
+}
+
+func (app *App) idleTimeout() time.Duration {
+	if app.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return app.IdleTimeout
+}
+
+// isAdmin reports if the user may view and revoke every user's sessions.
+func (app *App) isAdmin(user string) bool {
+	for _, admin := range app.Admins {
+		if admin == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Path: /sessions
+func (app *App) handleSessions(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	admin := app.isAdmin(user(ec2Cli))
+	filter := user(ec2Cli)
+	if admin {
+		filter = ""
+	}
+	current, _ := app.session(r).Values["sid"].(string)
+	data := map[string]interface{}{
+		"Sessions":       app.sessions.list(app.sessionTimeout(), app.idleTimeout(), filter),
+		"Current":        current,
+		"User":           user(ec2Cli),
+		"Admin":          admin,
+		"SessionTimeout": app.sessionTimeout(),
+		"IdleTimeout":    app.idleTimeout(),
+	}
+	app.render(w, r, "sessions.html", data)
+}
+
+// Path: /sessions/revoke
+func (app *App) handleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	target := r.PostFormValue("user")
+	if target == "" {
+		target = user(ec2Cli)
+	}
+	if target != user(ec2Cli) && !app.isAdmin(user(ec2Cli)) {
+		http.Error(w, "Only admins can log out other users", http.StatusForbidden)
+		return
+	}
+	n := app.sessions.revoke(target)
+	app.Logf("%s logged out %d sessions of %s", user(ec2Cli), n, target)
+	if target == user(ec2Cli) {
+		app.logout(w, r)
+		http.Redirect(w, r, app.url("/login"), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, app.url("/sessions"), http.StatusSeeOther)
+}
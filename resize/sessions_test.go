@@ -0,0 +1,166 @@
+package resize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	s := newSessionRegistry()
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, idle := 12*time.Hour, time.Hour
+	now := time.Now()
+
+	if !s.touch(timeout, idle, "a", "AKIDA", now, r) {
+		t.Fatal("expected a new session to be valid")
+	}
+	if s.touch(timeout, idle, "old", "AKIDA", now.Add(-13*time.Hour), r) {
+		t.Error("expected a session past the timeout to be invalid")
+	}
+
+	s.touch(timeout, idle, "idle", "AKIDA", now.Add(-2*time.Hour), r)
+	s.sessions["idle"].LastSeen = now.Add(-90 * time.Minute)
+	if s.touch(timeout, idle, "idle", "AKIDA", now.Add(-2*time.Hour), r) {
+		t.Error("expected an idle session to be invalid")
+	}
+
+	s.touch(timeout, idle, "b", "AKIDB", now, r)
+	if list := s.list(timeout, idle, ""); len(list) != 2 {
+		t.Errorf("expected 2 active sessions, got %v", list)
+	}
+	if list := s.list(timeout, idle, "AKIDB"); len(list) != 1 || list[0].Id != "b" {
+		t.Errorf("expected only the session of AKIDB, got %v", list)
+	}
+
+	s.end("b", now)
+	if s.touch(timeout, idle, "b", "AKIDB", now, r) {
+		t.Error("expected a logged out session to be invalid")
+	}
+
+	if n := s.revoke("AKIDA"); n != 1 {
+		t.Errorf("expected 1 session to be revoked, got %d", n)
+	}
+	if s.touch(timeout, idle, "a", "AKIDA", now, r) {
+		t.Error("expected a revoked session to be invalid")
+	}
+	if !s.touch(timeout, idle, "c", "AKIDA", time.Now(), r) {
+		t.Error("expected a session created after revoking to be valid")
+	}
+}
+
+func TestSessionSweep(t *testing.T) {
+	s := newSessionRegistry()
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.sessions["idle"] = &Session{Id: "idle", User: "AKIDALICE", Created: now, LastSeen: now.Add(-2 * time.Hour)}
+	s.ended["old"] = now.Add(-24 * time.Hour)
+	s.revoked["AKIDBOB"] = now.Add(-24 * time.Hour)
+
+	if !s.touch(12*time.Hour, time.Hour, "new", "AKIDALICE", now, r) {
+		t.Fatal("expected a new session to be valid")
+	}
+	if _, ok := s.sessions["idle"]; ok {
+		t.Error("expected the idle session to be removed")
+	}
+	if _, ok := s.ended["old"]; ok {
+		t.Error("expected the timed out session to be forgotten")
+	}
+	if len(s.revoked) != 0 {
+		t.Errorf("expected old revocations to be forgotten, got %v", s.revoked)
+	}
+
+	// sweeps are rate limited
+	s.ended["old"] = now.Add(-24 * time.Hour)
+	s.touch(12*time.Hour, time.Hour, "new", "AKIDALICE", now, r)
+	if _, ok := s.ended["old"]; !ok {
+		t.Error("expected no sweep within sweepInterval")
+	}
+}
+
+func TestSessionPages(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Admins = []string{"AKIDADMIN"}
+
+	login := func(accessKey string) string {
+		ec2Cli := ec2.New(aws.Auth{AccessKey: accessKey, SecretKey: "secret"}, aws.USEast)
+		app.identities.set(app.identityKey(ec2Cli), Identity{Account: "111", Principal: "arn:aws:iam::111:user/" + accessKey})
+		r, _ := http.NewRequest("POST", "/login", nil)
+		w := httptest.NewRecorder()
+		if err := app.start(w, r, ec2Cli); err != nil {
+			t.Fatal(err)
+		}
+		return w.Header().Get("Set-Cookie")
+	}
+	do := func(method, path, cookie string, form url.Values) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+		switch path {
+		case "/sessions":
+			app.handleSessions(w, r)
+		case "/sessions/revoke":
+			app.handleRevokeSessions(w, r)
+		case "/logout":
+			app.handleLogout(w, r)
+		}
+		return w
+	}
+	loggedIn := func(cookie string) bool {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Cookie", cookie)
+		_, ok := app.creds(r)
+		return ok
+	}
+
+	admin := login("AKIDADMIN")
+	user := login("AKIDUSER")
+	other := login("AKIDUSER")
+
+	w := do("GET", "/sessions", admin, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "AKIDUSER") {
+		t.Errorf("expected admins to see every session, got %d", w.Code)
+	}
+	w = do("GET", "/sessions", user, nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "AKIDADMIN") {
+		t.Errorf("expected users to only see their own sessions, got %d", w.Code)
+	}
+
+	w = do("POST", "/sessions/revoke", user, url.Values{"user": {"AKIDADMIN"}})
+	if w.Code != http.StatusForbidden || !loggedIn(admin) {
+		t.Errorf("expected users not to be able to log out others, got %d", w.Code)
+	}
+
+	w = do("POST", "/sessions/revoke", admin, url.Values{"user": {"AKIDUSER"}})
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("expected a redirect after logging out a user, got %d", w.Code)
+	}
+	if loggedIn(user) || loggedIn(other) {
+		t.Error("expected every session of the user to be logged out")
+	}
+	if !loggedIn(admin) {
+		t.Error("expected the admin to stay logged in")
+	}
+
+	// a cookie kept after logging out cannot be reused
+	do("GET", "/logout", admin, nil)
+	if loggedIn(admin) {
+		t.Error("expected the session to end on logout")
+	}
+}
@@ -0,0 +1,208 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mitchellh/goamz/ec2"
+)
+
+const (
+	// jobTag is the tag key identifying the resize job a resource was
+	// created for.
+	jobTag = "resize-job"
+
+	// defaultSnapshotRetention is used if the App's SnapshotRetention is
+	// not set.
+	defaultSnapshotRetention = 7 * 24 * time.Hour
+)
+
+// Snapshot options for a resize. With SnapshotPending the resize continues
+// once snapshots have been started, with SnapshotCompleted it waits for
+// them to finish.
+const (
+	SnapshotNone      = ""
+	SnapshotPending   = "pending"
+	SnapshotCompleted = "completed"
+)
+
+var (
+	snapshotPollInterval = 10 * time.Second
+	snapshotTimeout      = 2 * time.Hour
+)
+
+// sendEvent writes an event as JSON to w.
+func sendEvent(w io.Writer, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %v", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// snapshotVolumes snapshots every EBS volume attached to the instance and
+// tags the snapshots with the job ID. If waitFor is SnapshotCompleted it
+// blocks until the snapshots have completed.
+func snapshotVolumes(ec2Cli *ec2.EC2, w io.Writer, instance ec2.Instance, jobId, waitFor string) ([]string, error) {
+	var ids []string
+	for _, dev := range instance.BlockDevices {
+		if dev.VolumeId == "" {
+			continue
+		}
+		desc := fmt.Sprintf("pre-resize snapshot of %s (%s) on %s, job %s",
+			dev.VolumeId, dev.DeviceName, instance.InstanceId, jobId)
+		resp, err := ec2Cli.CreateSnapshot(dev.VolumeId, desc)
+		if err != nil {
+			return ids, fmt.Errorf("could not snapshot %s: %v", dev.VolumeId, err)
+		}
+		ids = append(ids, resp.Id)
+		sendEvent(w, Event{Status: "progress", Message: "started snapshot " + resp.Id + " of " + dev.VolumeId})
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	tags := []ec2.Tag{
+		{Key: jobTag, Value: jobId},
+		{Key: "resize-instance", Value: instance.InstanceId},
+	}
+	if _, err := ec2Cli.CreateTags(ids, tags); err != nil {
+		return ids, fmt.Errorf("could not tag snapshots: %v", err)
+	}
+	if waitFor != SnapshotCompleted {
+		return ids, nil
+	}
+	return ids, waitForSnapshots(ec2Cli, w, ids)
+}
+
+// waitForSnapshots polls until all snapshots have completed.
+func waitForSnapshots(ec2Cli *ec2.EC2, w io.Writer, ids []string) error {
+	deadline := time.Now().Add(snapshotTimeout)
+	for time.Now().Before(deadline) {
+		resp, err := ec2Cli.Snapshots(ids, nil)
+		if err != nil {
+			return fmt.Errorf("error checking snapshot status: %v", err)
+		}
+		done := 0
+		for _, snap := range resp.Snapshots {
+			switch snap.Status {
+			case "completed":
+				done++
+			case "error":
+				return fmt.Errorf("snapshot %s failed", snap.Id)
+			default:
+				sendEvent(w, Event{Status: "progress", Message: "snapshot " + snap.Id + " " + snap.Progress})
+			}
+		}
+		if done == len(ids) {
+			sendEvent(w, Event{Status: "progress", Message: "snapshots completed"})
+			return nil
+		}
+		time.Sleep(snapshotPollInterval)
+	}
+	return fmt.Errorf("timed out waiting for snapshots to complete")
+}
+
+// snapshotsOlderThan returns the snapshots started before cutoff.
+func snapshotsOlderThan(snaps []ec2.Snapshot, cutoff time.Time) []ec2.Snapshot {
+	var old []ec2.Snapshot
+	for _, snap := range snaps {
+		started, err := time.Parse(time.RFC3339, snap.StartTime)
+		if err != nil {
+			continue
+		}
+		if started.Before(cutoff) {
+			old = append(old, snap)
+		}
+	}
+	return old
+}
+
+// jobSnapshots returns all snapshots taken before resizes, newest first.
+func jobSnapshots(ec2Cli *ec2.EC2) ([]ec2.Snapshot, error) {
+	filter := ec2.NewFilter()
+	filter.Add("tag-key", jobTag)
+	resp, err := ec2Cli.Snapshots(nil, filter)
+	if err != nil {
+		return nil, err
+	}
+	snaps := resp.Snapshots
+	sort.Sort(byStartTime(snaps))
+	return snaps, nil
+}
+
+type byStartTime []ec2.Snapshot
+
+func (s byStartTime) Len() int           { return len(s) }
+func (s byStartTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStartTime) Less(i, j int) bool { return s[i].StartTime > s[j].StartTime }
+
+func (app *App) snapshotRetention() time.Duration {
+	if app.SnapshotRetention <= 0 {
+		return defaultSnapshotRetention
+	}
+	return app.SnapshotRetention
+}
+
+// Path: /snapshots
+func (app *App) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	snaps, err := jobSnapshots(ec2Cli)
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("Bad response from AWS %v", err))
+		return
+	}
+	expired := make(map[string]bool)
+	for _, snap := range snapshotsOlderThan(snaps, time.Now().Add(-app.snapshotRetention())) {
+		expired[snap.Id] = true
+	}
+	data := map[string]interface{}{
+		"Snapshots": snaps,
+		"Expired":   expired,
+		"Retention": app.snapshotRetention().String(),
+	}
+	app.render(w, r, "snapshots.html", data)
+}
+
+// Path: /snapshots/cleanup
+func (app *App) handleSnapshotCleanup(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	snaps, err := jobSnapshots(ec2Cli)
+	if err != nil {
+		app.render500(w, r, fmt.Errorf("Bad response from AWS %v", err))
+		return
+	}
+	for _, snap := range snapshotsOlderThan(snaps, time.Now().Add(-app.snapshotRetention())) {
+		if _, err := ec2Cli.DeleteSnapshots([]string{snap.Id}); err != nil {
+			app.render500(w, r, fmt.Errorf("could not delete snapshot %s: %v", snap.Id, err))
+			return
+		}
+		app.record(ec2Cli, HistoryEntry{
+			User:    user(ec2Cli),
+			Region:  ec2Cli.Region.Name,
+			Action:  "snapshot-cleanup",
+			Message: fmt.Sprintf("deleted snapshot %s of %s started %s", snap.Id, snap.VolumeId, snap.StartTime),
+		})
+	}
+	http.Redirect(w, r, app.url("/snapshots"), http.StatusSeeOther)
+}
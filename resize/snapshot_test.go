@@ -0,0 +1,116 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// snapshotServer is a stand-in for the EC2 snapshot calls. Snapshots
+// complete after being described twice.
+type snapshotServer struct {
+	mu        sync.Mutex
+	snapshots map[string]int // id to number of times described
+	tags      map[string]string
+}
+
+func (s *snapshotServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	switch q.Get("Action") {
+	case "CreateSnapshot":
+		id := fmt.Sprintf("snap-%d", len(s.snapshots)+1)
+		s.snapshots[id] = 0
+		fmt.Fprintf(w, `<CreateSnapshotResponse><requestId>r</requestId><snapshotId>%s</snapshotId>
+<volumeId>%s</volumeId><status>pending</status></CreateSnapshotResponse>`, id, q.Get("VolumeId"))
+	case "CreateTags":
+		for i := 1; q.Get(fmt.Sprintf("ResourceId.%d", i)) != ""; i++ {
+			s.tags[q.Get(fmt.Sprintf("ResourceId.%d", i))] = q.Get("Tag.1.Value")
+		}
+		fmt.Fprint(w, `<CreateTagsResponse><requestId>r</requestId><return>true</return></CreateTagsResponse>`)
+	case "DescribeSnapshots":
+		fmt.Fprint(w, `<DescribeSnapshotsResponse><requestId>r</requestId><snapshotSet>`)
+		for i := 1; q.Get(fmt.Sprintf("SnapshotId.%d", i)) != ""; i++ {
+			id := q.Get(fmt.Sprintf("SnapshotId.%d", i))
+			s.snapshots[id]++
+			status := "pending"
+			if s.snapshots[id] > 2 {
+				status = "completed"
+			}
+			fmt.Fprintf(w, `<item><snapshotId>%s</snapshotId><status>%s</status><progress>50%%</progress></item>`, id, status)
+		}
+		fmt.Fprint(w, `</snapshotSet></DescribeSnapshotsResponse>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestSnapshotVolumes(t *testing.T) {
+	defer func(d time.Duration) { snapshotPollInterval = d }(snapshotPollInterval)
+	snapshotPollInterval = time.Millisecond
+
+	srv := &snapshotServer{snapshots: make(map[string]int), tags: make(map[string]string)}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	instance := ec2.Instance{
+		InstanceId: "i-1234",
+		BlockDevices: []ec2.BlockDevice{
+			{DeviceName: "/dev/sda1", VolumeId: "vol-1"},
+			{DeviceName: "/dev/sdb", VolumeId: "vol-2"},
+		},
+	}
+	buf := new(bytes.Buffer)
+	ids, err := snapshotVolumes(ec2Cli, buf, instance, "job1", SnapshotCompleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 snapshots, got %v", ids)
+	}
+	for _, id := range ids {
+		if srv.tags[id] != "job1" {
+			t.Errorf("snapshot %s not tagged with job ID", id)
+		}
+		if srv.snapshots[id] < 3 {
+			t.Errorf("did not wait for snapshot %s to complete", id)
+		}
+	}
+	if !strings.Contains(buf.String(), "snapshots completed") {
+		t.Errorf("expected completion event, got %s", buf.String())
+	}
+
+	// with SnapshotPending the snapshots are not polled
+	ids, err = snapshotVolumes(ec2Cli, buf, instance, "job2", SnapshotPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if srv.snapshots[id] != 0 {
+			t.Errorf("snapshot %s should not have been polled", id)
+		}
+	}
+}
+
+func TestSnapshotsOlderThan(t *testing.T) {
+	now := time.Date(2015, 10, 15, 0, 0, 0, 0, time.UTC)
+	snaps := []ec2.Snapshot{
+		{Id: "snap-old", StartTime: "2015-10-01T00:00:00.000Z"},
+		{Id: "snap-new", StartTime: "2015-10-14T00:00:00.000Z"},
+		{Id: "snap-bad", StartTime: "yesterday"},
+	}
+	old := snapshotsOlderThan(snaps, now.Add(-7*24*time.Hour))
+	if len(old) != 1 || old[0].Id != "snap-old" {
+		t.Errorf("expected only snap-old to be expired, got %v", old)
+	}
+}
@@ -0,0 +1,182 @@
+package resize
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+// Limits EC2 places on user defined tags.
+const (
+	maxTagKeyLen   = 127
+	maxTagValueLen = 255
+)
+
+// validateTag checks a tag may be set by a user.
+func validateTag(tag ec2.Tag) error {
+	switch {
+	case tag.Key == "":
+		return fmt.Errorf("tag key cannot be empty")
+	case len(tag.Key) > maxTagKeyLen:
+		return fmt.Errorf("tag key %q is longer than %d characters", tag.Key, maxTagKeyLen)
+	case len(tag.Value) > maxTagValueLen:
+		return fmt.Errorf("value of tag %q is longer than %d characters", tag.Key, maxTagValueLen)
+	case strings.HasPrefix(strings.ToLower(tag.Key), "aws:"):
+		return fmt.Errorf("tag keys beginning with 'aws:' are reserved")
+	}
+	return nil
+}
+
+// guardedTag returns an error if the tag key is matched by the approval or
+// protected rules. Letting users change those tags would let them skip the
+// approval, or stop a protected instance, by editing a tag first.
+func (app *App) guardedTag(key string) error {
+	for _, rule := range []*TagRule{app.ApprovalRule, app.ProtectedRule} {
+		if rule != nil && rule.Key == key {
+			return fmt.Errorf("tag %q is used to control resizes and cannot be changed here", key)
+		}
+	}
+	return nil
+}
+
+// missingTags returns the required tag rules the tags do not satisfy.
+func (app *App) missingTags(tags []ec2.Tag) []TagRule {
+	var missing []TagRule
+	for _, rule := range app.RequiredTags {
+		if !rule.Match(tags) {
+			missing = append(missing, rule)
+		}
+	}
+	return missing
+}
+
+// checkInstances returns an error unless every ID is an instance in the
+// client's region, as CreateTags and DeleteTags accept any resource ID.
+func checkInstances(ec2Cli *ec2.EC2, ids []string) error {
+	for _, id := range ids {
+		if !strings.HasPrefix(id, "i-") {
+			return fmt.Errorf("%s is not an instance", id)
+		}
+	}
+	resp, err := ec2Cli.Instances(ids, nil)
+	if err != nil {
+		return fmt.Errorf("could not find instances: %v", err)
+	}
+	found := make(map[string]bool)
+	for _, instance := range allInstances(resp) {
+		found[instance.InstanceId] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return fmt.Errorf("instance %s not found in %s", id, ec2Cli.Region.Name)
+		}
+	}
+	return nil
+}
+
+// Path: /instance/{instance}/tags
+func (app *App) handleTags(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	tag := ec2.Tag{Key: strings.TrimSpace(r.FormValue("key")), Value: r.FormValue("value")}
+	if err := validateTag(tag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := app.guardedTag(tag.Key); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := checkInstances(ec2Cli, []string{instanceId}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var msg string
+	switch op := r.FormValue("op"); op {
+	case "set":
+		if _, err := ec2Cli.CreateTags([]string{instanceId}, []ec2.Tag{tag}); err != nil {
+			app.render500(w, r, fmt.Errorf("could not set tag: %v", err))
+			return
+		}
+		msg = fmt.Sprintf("set tag %s=%s", tag.Key, tag.Value)
+	case "delete":
+		// only the key is sent so the tag is deleted whatever its value
+		if _, err := ec2Cli.DeleteTags([]string{instanceId}, []ec2.Tag{{Key: tag.Key}}); err != nil {
+			app.render500(w, r, fmt.Errorf("could not delete tag: %v", err))
+			return
+		}
+		msg = "deleted tag " + tag.Key
+	default:
+		http.Error(w, "Operation must be 'set' or 'delete'", http.StatusBadRequest)
+		return
+	}
+	app.record(ec2Cli, HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     ec2Cli.Region.Name,
+		InstanceId: instanceId,
+		Action:     "tag",
+		Message:    msg,
+	})
+	http.Redirect(w, r, app.url("/instance/"+instanceId), http.StatusSeeOther)
+}
+
+// Path: /tags
+func (app *App) handleBulkTag(w http.ResponseWriter, r *http.Request) {
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not implemented", http.StatusNotImplemented)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := r.PostForm["instance"]
+	if len(ids) == 0 {
+		http.Error(w, "No instances selected", http.StatusBadRequest)
+		return
+	}
+	tag := ec2.Tag{Key: strings.TrimSpace(r.PostFormValue("key")), Value: r.PostFormValue("value")}
+	if err := validateTag(tag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := app.guardedTag(tag.Key); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := checkInstances(ec2Cli, ids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := ec2Cli.CreateTags(ids, []ec2.Tag{tag}); err != nil {
+		app.render500(w, r, fmt.Errorf("could not set tags: %v", err))
+		return
+	}
+	for _, id := range ids {
+		app.record(ec2Cli, HistoryEntry{
+			User:       user(ec2Cli),
+			Region:     ec2Cli.Region.Name,
+			InstanceId: id,
+			Action:     "tag",
+			Message:    fmt.Sprintf("set tag %s=%s", tag.Key, tag.Value),
+		})
+	}
+	http.Redirect(w, r, app.url("/"), http.StatusSeeOther)
+}
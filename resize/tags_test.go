@@ -0,0 +1,105 @@
+package resize
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/goamz/ec2/ec2test"
+)
+
+func TestValidateTag(t *testing.T) {
+	tests := []struct {
+		tag ec2.Tag
+		ok  bool
+	}{
+		{ec2.Tag{Key: "Owner", Value: "ops"}, true},
+		{ec2.Tag{Key: "Owner"}, true},
+		{ec2.Tag{Key: ""}, false},
+		{ec2.Tag{Key: "aws:cloudformation:stack-name"}, false},
+		{ec2.Tag{Key: "AWS:thing"}, false},
+		{ec2.Tag{Key: strings.Repeat("k", 128)}, false},
+		{ec2.Tag{Key: "Owner", Value: strings.Repeat("v", 256)}, false},
+	}
+	for _, test := range tests {
+		if ok := validateTag(test.tag) == nil; ok != test.ok {
+			t.Errorf("%+v: expected ok=%t", test.tag, test.ok)
+		}
+	}
+}
+
+func TestMissingTags(t *testing.T) {
+	app := &App{RequiredTags: []TagRule{{Key: "Owner"}, {Key: "CostCenter"}, {Key: "env", Value: "prod"}}}
+	tags := []ec2.Tag{{Key: "Owner", Value: "ops"}, {Key: "env", Value: "dev"}}
+	missing := app.missingTags(tags)
+	if len(missing) != 2 || missing[0].Key != "CostCenter" || missing[1].String() != "env=prod" {
+		t.Errorf("expected CostCenter and env=prod to be missing, got %v", missing)
+	}
+	if missing := (&App{}).missingTags(nil); len(missing) != 0 {
+		t.Errorf("expected nothing missing without rules, got %v", missing)
+	}
+}
+
+func TestGuardedTags(t *testing.T) {
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ApprovalRule = &TagRule{Key: "approval", Value: "required"}
+	app.ProtectedRule = &TagRule{Key: "protected"}
+	// the guard applies before any call to EC2
+	s := loginSession(t, app, "AKIDALICE", aws.Region{Name: "test", EC2Endpoint: "http://127.0.0.1:1"})
+
+	for _, key := range []string{"approval", "protected"} {
+		for _, op := range []string{"set", "delete"} {
+			w := s.do("POST", "/instance/i-1/tags", url.Values{"op": {op}, "key": {key}, "value": {"no"}})
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s: expected the tag change to be forbidden, got %d", op, key, w.Code)
+			}
+		}
+		w := s.do("POST", "/tags", url.Values{"instance": {"i-1", "i-2"}, "key": {key}, "value": {"no"}})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("bulk %s: expected the tag change to be forbidden, got %d", key, w.Code)
+		}
+	}
+	if err := app.guardedTag("Owner"); err != nil {
+		t.Errorf("expected other tags to be allowed, got %v", err)
+	}
+}
+
+func TestTagsOnlyInstances(t *testing.T) {
+	srv, err := ec2test.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Quit()
+	app, err := NewApp("../public", "../templates", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := aws.Region{Name: "test", EC2Endpoint: srv.URL()}
+	s := loginSession(t, app, "AKIDALICE", region)
+	ec2Cli := ec2.New(aws.Auth{}, region)
+	resp, err := ec2Cli.RunInstances(&ec2.RunInstances{ImageId: "ami-1", InstanceType: "m3.large", MinCount: 1, MaxCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceId := resp.Instances[0].InstanceId
+
+	for _, id := range []string{"vol-1234", "sg-1234", "i-nothere"} {
+		w := s.do("POST", "/instance/"+id+"/tags", url.Values{"op": {"set"}, "key": {"Owner"}, "value": {"ops"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected tagging to be refused, got %d", id, w.Code)
+		}
+		w = s.do("POST", "/tags", url.Values{"instance": {instanceId, id}, "key": {"Owner"}, "value": {"ops"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("bulk %s: expected tagging to be refused, got %d", id, w.Code)
+		}
+	}
+	if err := checkInstances(ec2Cli, []string{instanceId}); err != nil {
+		t.Errorf("expected %s to be an instance, got %v", instanceId, err)
+	}
+}
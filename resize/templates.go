@@ -2,12 +2,10 @@ package resize
 
 import (
 	"html/template"
-	"io/ioutil"
+	"io/fs"
 	"net/http"
-	"path/filepath"
 	"strings"
 
-	"github.com/mitchellh/goamz/aws"
 	"golang.org/x/net/websocket"
 )
 
@@ -27,8 +25,8 @@ var helpers = template.FuncMap{
 }
 
 // CompileTemplates parses a template directory
-func (app *App) compileTemplates(tmplDir string) error {
-	tmpl, err := compileTemplates(tmplDir)
+func (app *App) compileTemplates(templates fs.FS) error {
+	tmpl, err := compileTemplates(templates)
 	if err != nil {
 		return err
 	}
@@ -36,23 +34,18 @@ func (app *App) compileTemplates(tmplDir string) error {
 	return nil
 }
 
-func compileTemplates(tmplDir string) (map[string]*template.Template, error) {
-	join := filepath.Join
-
-	includes := join(tmplDir, "includes")
-	layouts := join(tmplDir, "layouts")
-
+func compileTemplates(templates fs.FS) (map[string]*template.Template, error) {
 	tmpl := template.New("").Funcs(helpers)
 	var err error
-	_, err = tmpl.ParseGlob(join(includes, "*.html"))
+	_, err = tmpl.ParseFS(templates, "includes/*.html")
 	if err != nil {
 		return nil, err
 	}
-	if _, err = tmpl.ParseGlob(join(layouts, "*.html")); err != nil {
+	if _, err = tmpl.ParseFS(templates, "layouts/*.html"); err != nil {
 		return nil, err
 	}
 
-	files, err := ioutil.ReadDir(tmplDir)
+	files, err := fs.ReadDir(templates, ".")
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +60,7 @@ func compileTemplates(tmplDir string) (map[string]*template.Template, error) {
 		if err != nil {
 			return nil, err
 		}
-		_, err = t.ParseFiles(join(tmplDir, name))
+		_, err = t.ParseFS(templates, name)
 		if err != nil {
 			return nil, err
 		}
@@ -81,32 +74,29 @@ func (app *App) render(w http.ResponseWriter, r *http.Request, name string, data
 	ec2Cli, ok := app.creds(r)
 	if ok {
 		// if the user is logged in display the list of available regions
-		regions := []struct {
+		type region struct {
 			Name     string
 			Selected bool
-		}{
-			{aws.APNortheast.Name, false},
-			{aws.APSoutheast.Name, false},
-			{aws.APSoutheast2.Name, false},
-			{aws.EUWest.Name, false},
-			{aws.EUCentral.Name, false},
-			{aws.USEast.Name, false},
-			{aws.USWest.Name, false},
-			{aws.USWest2.Name, false},
-			{aws.SAEast.Name, false},
-			{aws.USGovWest.Name, false},
-			{aws.CNNorth.Name, false},
 		}
-		for i, r := range regions {
-			if r.Name == ec2Cli.Region.Name {
-				regions[i].Selected = true
-				break
-			}
+		var regions []region
+		for _, r := range app.accountRegions(ec2Cli) {
+			regions = append(regions, region{r.Name, r.Name == ec2Cli.Region.Name})
 		}
 		if data == nil {
 			data = make(map[string]interface{})
 		}
 		data["Regions"] = regions
+		data["Region"] = ec2Cli.Region.Name
+		data["NoAddresses"] = !app.features.supported(ec2Cli.Region.Name, featureAddresses)
+	}
+	token, err := app.csrfToken(w, r)
+	if err != nil {
+		app.Logf("could not create csrf token: %v", err)
+	} else {
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+		data["CSRFToken"] = token
 	}
 	app.renderStatus(w, r, name, data, http.StatusOK)
 }
@@ -114,7 +104,7 @@ func (app *App) render(w http.ResponseWriter, r *http.Request, name string, data
 // Render500 renders the 500.html template with the error message displayed to
 // the user.
 func (app *App) render500(w http.ResponseWriter, r *http.Request, err error) {
-	data := map[string]string{
+	data := map[string]interface{}{
 		"Error": err.Error(),
 	}
 	app.renderStatus(w, r, "500.html", data, http.StatusInternalServerError)
@@ -127,7 +117,7 @@ func (app *App) render404(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) wsErr(ws *websocket.Conn, err string) {
-	app.Logf(err)
+	app.Logf("%s", err)
 	e := Event{Status: "error", Message: err}
 	websocket.JSON.Send(ws, &e)
 }
@@ -136,11 +126,11 @@ func (app *App) renderStatus(
 	w http.ResponseWriter,
 	r *http.Request,
 	name string,
-	data interface{},
+	data map[string]interface{},
 	status int) {
 
 	if app.ReloadTemplates {
-		err := app.compileTemplates(app.tmplDir)
+		err := app.compileTemplates(app.templates)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -153,6 +143,12 @@ func (app *App) renderStatus(
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	// every page links relative to the base path
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["BasePath"] = app.BasePath
+
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(status)
 
@@ -2,6 +2,7 @@ package resize
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -9,7 +10,7 @@ import (
 
 func TestCompilteTemplates(t *testing.T) {
 	tmplDir := "../templates"
-	tmpl, err := compileTemplates(tmplDir)
+	tmpl, err := compileTemplates(os.DirFS(tmplDir))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,3 +37,32 @@ func TestCompilteTemplates(t *testing.T) {
 		}
 	}
 }
+
+// The Content-Security-Policy does not allow inline scripts.
+func TestNoInlineScripts(t *testing.T) {
+	files, err := filepath.Glob("../templates/*/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := filepath.Glob("../templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range append(top, files...) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		html := strings.ToLower(string(b))
+		for _, tag := range strings.Split(html, "<script")[1:] {
+			if end := strings.Index(tag, ">"); end < 0 || !strings.Contains(tag[:end], "src=") {
+				t.Errorf("%s: inline script", file)
+			}
+		}
+		for _, attr := range []string{" onclick=", " onchange=", " onsubmit=", " onload=", "javascript:"} {
+			if strings.Contains(html, attr) {
+				t.Errorf("%s: inline event handler %s", file, strings.TrimSpace(attr))
+			}
+		}
+	}
+}
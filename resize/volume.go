@@ -0,0 +1,313 @@
+package resize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/goamz/ec2"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	volumePollInterval = 5 * time.Second
+	volumeTimeout      = 30 * time.Minute
+)
+
+// volumeTypes are the EBS volume types a volume can be changed to.
+var volumeTypes = []string{"standard", "gp2", "io1", "st1", "sc1"}
+
+// volumeRequest is the message sent by the client to grow or change the type
+// of a volume. VolumeType and IOPS default to the current volume's.
+type volumeRequest struct {
+	VolumeId   string
+	Size       int64
+	VolumeType string
+	IOPS       int64
+}
+
+func parseVolumeRequest(msg string) (volumeRequest, error) {
+	var req volumeRequest
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return req, fmt.Errorf("malformed volume request: %v", err)
+	}
+	if req.VolumeId == "" {
+		return req, fmt.Errorf("no volume provided")
+	}
+	if req.Size <= 0 {
+		return req, fmt.Errorf("volume size must be positive")
+	}
+	if req.VolumeType != "" {
+		known := false
+		for _, t := range volumeTypes {
+			known = known || t == req.VolumeType
+		}
+		if !known {
+			return req, fmt.Errorf("unknown volume type %q", req.VolumeType)
+		}
+	}
+	if req.VolumeType == "io1" && req.IOPS <= 0 {
+		return req, fmt.Errorf("provisioned IOPS volumes require IOPS")
+	}
+	return req, nil
+}
+
+// instanceVolumes returns the volumes attached to an instance.
+func instanceVolumes(ec2Cli *ec2.EC2, instanceId string) ([]ec2.Volume, error) {
+	filter := ec2.NewFilter()
+	filter.Add("attachment.instance-id", instanceId)
+	resp, err := ec2Cli.Volumes(nil, filter)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+// attachment returns the volume's attachment to the instance.
+func attachment(vol ec2.Volume, instanceId string) (ec2.VolumeAttachment, bool) {
+	for _, a := range vol.Attachments {
+		if a.InstanceId == instanceId {
+			return a, true
+		}
+	}
+	return ec2.VolumeAttachment{}, false
+}
+
+// replacementVolume builds the options to create a replacement for vol from
+// a snapshot. The new volume must be at least as large as the old one.
+func replacementVolume(vol ec2.Volume, snapshotId string, req volumeRequest) (*ec2.CreateVolume, error) {
+	size, err := strconv.ParseInt(vol.Size, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse size of %s: %v", vol.VolumeId, err)
+	}
+	if req.Size < size {
+		return nil, fmt.Errorf("volumes cannot shrink, %s is %d GiB", vol.VolumeId, size)
+	}
+	opts := &ec2.CreateVolume{
+		AvailZone:  vol.AvailZone,
+		Size:       req.Size,
+		SnapshotId: snapshotId,
+		VolumeType: vol.VolumeType,
+		IOPS:       vol.IOPS,
+		Encrypted:  vol.Encrypted,
+	}
+	if req.VolumeType != "" {
+		opts.VolumeType = req.VolumeType
+	}
+	if req.IOPS > 0 {
+		opts.IOPS = req.IOPS
+	}
+	if opts.VolumeType != "io1" {
+		// only provisioned IOPS volumes accept an IOPS parameter
+		opts.IOPS = 0
+	}
+	if req.Size == size && opts.VolumeType == vol.VolumeType && opts.IOPS == vol.IOPS {
+		return nil, fmt.Errorf("nothing to change for %s", vol.VolumeId)
+	}
+	return opts, nil
+}
+
+// waitForVolume polls until a volume reaches the given status.
+func waitForVolume(ec2Cli *ec2.EC2, w io.Writer, volumeId, status string) error {
+	deadline := time.Now().Add(volumeTimeout)
+	for time.Now().Before(deadline) {
+		resp, err := ec2Cli.Volumes([]string{volumeId}, nil)
+		if err != nil {
+			return fmt.Errorf("error checking volume status: %v", err)
+		}
+		if len(resp.Volumes) == 1 {
+			switch s := resp.Volumes[0].Status; s {
+			case status:
+				return nil
+			case "error":
+				return fmt.Errorf("volume %s failed", volumeId)
+			default:
+				sendEvent(w, Event{Status: "progress", Message: "volume " + volumeId + " " + s})
+			}
+		}
+		time.Sleep(volumePollInterval)
+	}
+	return fmt.Errorf("timed out waiting for volume %s to be %s", volumeId, status)
+}
+
+// growVolume replaces a volume attached to an instance with a larger copy or
+// one of a different type. With the instance stopped, so nothing is written
+// after the snapshot is taken, the volume is snapshotted, a new volume
+// created from the snapshot in the same availability zone, and the old
+// volume detached and the new one attached at the same device. The old
+// volume is kept so it can be reattached by hand. It returns the ID of the
+// new volume.
+func growVolume(ec2Cli *ec2.EC2, w io.Writer, instanceId, state string, req volumeRequest, jobId string) (newId string, err error) {
+	if state != "running" && state != "stopped" {
+		return "", fmt.Errorf("The server's state must be either 'stopped' or 'running' to change its volumes.")
+	}
+	resp, err := ec2Cli.Volumes([]string{req.VolumeId}, nil)
+	if err != nil {
+		return "", fmt.Errorf("Bad response from AWS %v", err)
+	}
+	if len(resp.Volumes) != 1 {
+		return "", fmt.Errorf("volume %s not found", req.VolumeId)
+	}
+	vol := resp.Volumes[0]
+	attached, ok := attachment(vol, instanceId)
+	if !ok {
+		return "", fmt.Errorf("volume %s is not attached to %s", vol.VolumeId, instanceId)
+	}
+	// validate before stopping the instance
+	if _, err := replacementVolume(vol, "", req); err != nil {
+		return "", err
+	}
+
+	// detaching is set once the old volume is being swapped out, after which
+	// the instance is only started again if the new volume is attached
+	detaching := false
+	if state == "running" {
+		if err := stopAndWait(ec2Cli, w, instanceId); err != nil {
+			return "", err
+		}
+		defer func() {
+			if err == nil || detaching {
+				return
+			}
+			if _, serr := ec2Cli.StartInstances(instanceId); serr != nil {
+				err = fmt.Errorf("%v, and could not start the instance again: %v", err, serr)
+			}
+		}()
+	}
+
+	tags := []ec2.Tag{
+		{Key: jobTag, Value: jobId},
+		{Key: "resize-instance", Value: instanceId},
+	}
+	desc := fmt.Sprintf("snapshot of %s (%s) on %s before volume change, job %s",
+		vol.VolumeId, attached.Device, instanceId, jobId)
+	snap, err := ec2Cli.CreateSnapshot(vol.VolumeId, desc)
+	if err != nil {
+		return "", fmt.Errorf("could not snapshot %s: %v", vol.VolumeId, err)
+	}
+	sendEvent(w, Event{Status: "progress", Message: "started snapshot " + snap.Id + " of " + vol.VolumeId})
+	if _, err := ec2Cli.CreateTags([]string{snap.Id}, tags); err != nil {
+		return "", fmt.Errorf("could not tag snapshot: %v", err)
+	}
+	if err := waitForSnapshots(ec2Cli, w, []string{snap.Id}); err != nil {
+		return "", err
+	}
+
+	opts, err := replacementVolume(vol, snap.Id, req)
+	if err != nil {
+		return "", err
+	}
+	created, err := ec2Cli.CreateVolume(opts)
+	if err != nil {
+		return "", fmt.Errorf("could not create volume: %v", err)
+	}
+	newId = created.VolumeId
+	sendEvent(w, Event{Status: "progress", Message: "creating volume " + newId})
+	for _, tag := range vol.Tags {
+		// tags with the aws: prefix are reserved
+		if !strings.HasPrefix(tag.Key, "aws:") {
+			tags = append(tags, tag)
+		}
+	}
+	if _, err := ec2Cli.CreateTags([]string{newId}, tags); err != nil {
+		return newId, fmt.Errorf("could not tag volume: %v", err)
+	}
+	if err := waitForVolume(ec2Cli, w, newId, "available"); err != nil {
+		return newId, err
+	}
+
+	detaching = true
+	if _, err := ec2Cli.DetachVolume(vol.VolumeId); err != nil {
+		return newId, fmt.Errorf("could not detach %s: %v", vol.VolumeId, err)
+	}
+	sendEvent(w, Event{Status: "progress", Message: "detaching " + vol.VolumeId})
+	if err := waitForVolume(ec2Cli, w, vol.VolumeId, "available"); err != nil {
+		return newId, err
+	}
+	if _, err := ec2Cli.AttachVolume(newId, instanceId, attached.Device); err != nil {
+		// put the old volume back so the instance is left as it was
+		if _, rerr := ec2Cli.AttachVolume(vol.VolumeId, instanceId, attached.Device); rerr != nil {
+			return newId, fmt.Errorf("could not attach %s: %v, and could not reattach %s: %v",
+				newId, err, vol.VolumeId, rerr)
+		}
+		return newId, fmt.Errorf("could not attach %s, reattached %s: %v", newId, vol.VolumeId, err)
+	}
+	sendEvent(w, Event{Status: "progress", Message: "attaching " + newId + " at " + attached.Device})
+	if err := waitForVolume(ec2Cli, w, newId, "in-use"); err != nil {
+		return newId, err
+	}
+	if state == "running" {
+		if _, err := ec2Cli.StartInstances(instanceId); err != nil {
+			return newId, fmt.Errorf("error starting instance: %v", err)
+		}
+		if err := pollUntilRunning(ec2Cli, w, instanceId); err != nil {
+			return newId, err
+		}
+	}
+	return newId, nil
+}
+
+// Path: /instance/{instance}/volume
+func (app *App) handleVolume(ws *websocket.Conn) {
+	defer ws.Close()
+
+	r := ws.Request()
+	ec2Cli, ok := app.creds(r)
+	if !ok {
+		app.wsErr(ws, "Unauthorized")
+		return
+	}
+	instanceId := mux.Vars(r)["instance"]
+	if instanceId == "" {
+		app.wsErr(ws, "No instance ID included")
+		return
+	}
+	currentStatus := r.URL.Query().Get("status")
+
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
+		app.wsErr(ws, fmt.Sprintf("error receiving websocket message: %v", err))
+		return
+	}
+	req, err := parseVolumeRequest(msg)
+	if err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	if err := app.checkProtected(ec2Cli, instanceId); err != nil {
+		app.wsErr(ws, err.Error())
+		return
+	}
+	job, err := app.jobs.start(app.identity(ec2Cli).Account, ec2Cli.Region.Name, instanceId, user(ec2Cli), "")
+	if err != nil {
+		app.wsErr(ws, fmt.Sprintf("could not start job: %v", err))
+		return
+	}
+	entry := HistoryEntry{
+		User:       user(ec2Cli),
+		Region:     ec2Cli.Region.Name,
+		InstanceId: instanceId,
+		JobId:      job.Id,
+		Action:     "volume",
+	}
+
+	start := time.Now()
+	newVolume, err := growVolume(ec2Cli, ws, instanceId, currentStatus, req, job.Id)
+	app.jobs.finish(job.Id, err)
+	if err != nil {
+		app.wsErr(ws, err.Error())
+		entry.Message = fmt.Sprintf("failed to replace %s: %v", req.VolumeId, err)
+		app.record(ec2Cli, entry)
+		return
+	}
+	app.metrics.phaseDuration.since("volume", start)
+	entry.Message = fmt.Sprintf("replaced %s with %s (%d GiB), resources tagged %s=%s",
+		req.VolumeId, newVolume, req.Size, jobTag, job.Id)
+	app.record(ec2Cli, entry)
+	e := Event{Status: "success"}
+	websocket.JSON.Send(ws, &e)
+}
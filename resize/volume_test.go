@@ -0,0 +1,209 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+)
+
+func TestParseVolumeRequest(t *testing.T) {
+	tests := []struct {
+		msg string
+		ok  bool
+	}{
+		{`{"VolumeId":"vol-1","Size":100}`, true},
+		{`{"VolumeId":"vol-1","Size":100,"VolumeType":"gp2"}`, true},
+		{`{"VolumeId":"vol-1","Size":100,"VolumeType":"io1","IOPS":1000}`, true},
+		{`{"VolumeId":"vol-1","Size":100,"VolumeType":"io1"}`, false},
+		{`{"VolumeId":"vol-1","Size":100,"VolumeType":"floppy"}`, false},
+		{`{"VolumeId":"vol-1"}`, false},
+		{`{"Size":100}`, false},
+		{`vol-1`, false},
+	}
+	for _, test := range tests {
+		_, err := parseVolumeRequest(test.msg)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: expected ok=%t, got error %v", test.msg, test.ok, err)
+		}
+	}
+}
+
+func TestReplacementVolume(t *testing.T) {
+	vol := ec2.Volume{VolumeId: "vol-1", Size: "50", AvailZone: "us-east-1a", VolumeType: "gp2", Encrypted: true}
+	tests := []struct {
+		req  volumeRequest
+		ok   bool
+		want ec2.CreateVolume
+	}{
+		{volumeRequest{Size: 100}, true,
+			ec2.CreateVolume{AvailZone: "us-east-1a", Size: 100, SnapshotId: "snap-1", VolumeType: "gp2", Encrypted: true}},
+		{volumeRequest{Size: 50, VolumeType: "io1", IOPS: 500}, true,
+			ec2.CreateVolume{AvailZone: "us-east-1a", Size: 50, SnapshotId: "snap-1", VolumeType: "io1", IOPS: 500, Encrypted: true}},
+		{volumeRequest{Size: 50, IOPS: 500}, false, ec2.CreateVolume{}},
+		{volumeRequest{Size: 50}, false, ec2.CreateVolume{}},
+		{volumeRequest{Size: 10}, false, ec2.CreateVolume{}},
+	}
+	for _, test := range tests {
+		opts, err := replacementVolume(vol, "snap-1", test.req)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%+v: expected ok=%t, got error %v", test.req, test.ok, err)
+			continue
+		}
+		if test.ok && *opts != test.want {
+			t.Errorf("%+v: expected %+v, got %+v", test.req, test.want, *opts)
+		}
+	}
+}
+
+// volumeServer is a stand-in for the EC2 volume, snapshot and instance
+// state calls. Snapshots complete and the instance stops and starts
+// immediately, and volumes change state when they are described.
+type volumeServer struct {
+	mu       sync.Mutex
+	volumes  map[string]string // id to status
+	attached map[string]string // id to device
+	instance string            // state of i-1234
+	actions  []string
+}
+
+func (s *volumeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	action := q.Get("Action")
+	s.actions = append(s.actions, action)
+	switch action {
+	case "DescribeVolumes":
+		id := q.Get("VolumeId.1")
+		status := s.volumes[id]
+		switch status {
+		case "creating", "detaching":
+			s.volumes[id] = "available"
+		case "attaching":
+			s.volumes[id] = "in-use"
+		}
+		attachment := ""
+		if dev, ok := s.attached[id]; ok {
+			attachment = fmt.Sprintf(`<item><volumeId>%s</volumeId><instanceId>i-1234</instanceId><device>%s</device></item>`, id, dev)
+		}
+		fmt.Fprintf(w, `<DescribeVolumesResponse><requestId>r</requestId><volumeSet><item>
+<volumeId>%s</volumeId><size>50</size><availabilityZone>us-east-1a</availabilityZone><status>%s</status>
+<volumeType>gp2</volumeType><attachmentSet>%s</attachmentSet></item></volumeSet></DescribeVolumesResponse>`,
+			id, status, attachment)
+	case "CreateSnapshot":
+		fmt.Fprint(w, `<CreateSnapshotResponse><requestId>r</requestId><snapshotId>snap-1</snapshotId></CreateSnapshotResponse>`)
+	case "DescribeSnapshots":
+		fmt.Fprint(w, `<DescribeSnapshotsResponse><requestId>r</requestId><snapshotSet>
+<item><snapshotId>snap-1</snapshotId><status>completed</status></item></snapshotSet></DescribeSnapshotsResponse>`)
+	case "CreateTags":
+		fmt.Fprint(w, `<CreateTagsResponse><requestId>r</requestId><return>true</return></CreateTagsResponse>`)
+	case "CreateVolume":
+		s.volumes["vol-2"] = "creating"
+		fmt.Fprint(w, `<CreateVolumeResponse><requestId>r</requestId><volumeId>vol-2</volumeId></CreateVolumeResponse>`)
+	case "DetachVolume":
+		id := q.Get("VolumeId")
+		s.volumes[id] = "detaching"
+		delete(s.attached, id)
+		fmt.Fprint(w, `<DetachVolumeResponse><requestId>r</requestId><return>true</return></DetachVolumeResponse>`)
+	case "AttachVolume":
+		id := q.Get("VolumeId")
+		s.volumes[id] = "attaching"
+		s.attached[id] = q.Get("Device")
+		fmt.Fprintf(w, `<AttachVolumeResponse><requestId>r</requestId><volumeId>%s</volumeId></AttachVolumeResponse>`, id)
+	case "StopInstances":
+		s.instance = "stopped"
+		fmt.Fprint(w, `<StopInstancesResponse><requestId>r</requestId></StopInstancesResponse>`)
+	case "StartInstances":
+		s.instance = "running"
+		fmt.Fprint(w, `<StartInstancesResponse><requestId>r</requestId></StartInstancesResponse>`)
+	case "DescribeInstanceStatus":
+		code := map[string]int{"running": 16, "stopped": 80}[s.instance]
+		fmt.Fprintf(w, `<DescribeInstanceStatusResponse><requestId>r</requestId><instanceStatusSet>
+<item><instanceId>i-1234</instanceId><instanceState><code>%d</code><name>%s</name></instanceState></item>
+</instanceStatusSet></DescribeInstanceStatusResponse>`, code, s.instance)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestGrowVolume(t *testing.T) {
+	defer func(d time.Duration) { volumePollInterval = d }(volumePollInterval)
+	volumePollInterval = time.Millisecond
+
+	srv := &volumeServer{
+		volumes:  map[string]string{"vol-1": "in-use"},
+		attached: map[string]string{"vol-1": "/dev/sdf"},
+	}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	buf := new(bytes.Buffer)
+	req := volumeRequest{VolumeId: "vol-1", Size: 100}
+	newId, err := growVolume(ec2Cli, buf, "i-1234", "stopped", req, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newId != "vol-2" {
+		t.Errorf("expected new volume vol-2, got %q", newId)
+	}
+	if dev := srv.attached["vol-2"]; dev != "/dev/sdf" {
+		t.Errorf("new volume should be attached at /dev/sdf, got %q", dev)
+	}
+	if _, ok := srv.attached["vol-1"]; ok {
+		t.Errorf("old volume should be detached")
+	}
+	if srv.volumes["vol-1"] != "available" {
+		t.Errorf("old volume should be kept, got status %q", srv.volumes["vol-1"])
+	}
+	for _, action := range srv.actions {
+		if action == "StopInstances" || action == "StartInstances" {
+			t.Errorf("stopped instance should not be stopped or started")
+		}
+	}
+
+	if _, err := growVolume(ec2Cli, buf, "i-1234", "pending", req, "job2"); err == nil {
+		t.Errorf("expected error for pending instance")
+	}
+}
+
+func TestGrowRunningVolume(t *testing.T) {
+	defer func(d time.Duration) { volumePollInterval = d }(volumePollInterval)
+	volumePollInterval = time.Millisecond
+	defer func(d time.Duration) { lifecyclePollInterval = d }(lifecyclePollInterval)
+	lifecyclePollInterval = time.Millisecond
+
+	srv := &volumeServer{
+		volumes:  map[string]string{"vol-1": "in-use"},
+		attached: map[string]string{"vol-1": "/dev/sdf"},
+		instance: "running",
+	}
+	s := httptest.NewServer(srv)
+	defer s.Close()
+	ec2Cli := ec2.New(aws.Auth{}, aws.Region{Name: "test", EC2Endpoint: s.URL})
+
+	req := volumeRequest{VolumeId: "vol-1", Size: 100}
+	if _, err := growVolume(ec2Cli, new(bytes.Buffer), "i-1234", "running", req, "job1"); err != nil {
+		t.Fatal(err)
+	}
+	order := make(map[string]int)
+	for i, action := range srv.actions {
+		if _, ok := order[action]; !ok {
+			order[action] = i
+		}
+	}
+	stop, ok := order["StopInstances"]
+	if !ok || stop > order["CreateSnapshot"] {
+		t.Errorf("expected the instance to be stopped before the snapshot, got %v", srv.actions)
+	}
+	if srv.instance != "running" {
+		t.Errorf("expected the instance to be started again, got %q", srv.instance)
+	}
+}